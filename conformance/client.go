@@ -0,0 +1,56 @@
+package conformance
+
+import (
+	"net/rpc"
+
+	"github.com/rstutsman/cs6450-labs/kvs"
+)
+
+// client is a minimal single-shard RPC connection, stripped down from
+// kvs/client.Client: conformance vectors only ever run against one shard
+// (see -server), so there's no replica group, leader caching, or failover
+// to thread through here.
+type client struct {
+	rpcClient *rpc.Client
+}
+
+func dial(addr string) (*client, error) {
+	rpcClient, err := rpc.DialHTTP("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &client{rpcClient}, nil
+}
+
+func (c *client) get(key string, txid uint64) (string, uint64, error) {
+	request := kvs.GetRequest{Key: key, Txid: txid}
+	response := kvs.GetResponse{}
+	err := c.rpcClient.Call("KVService.Get", &request, &response)
+	return response.Value, response.Version, err
+}
+
+func (c *client) put(key, value string, txid uint64) error {
+	request := kvs.PutRequest{Key: key, Value: value, Txid: txid}
+	response := kvs.PutResponse{}
+	return c.rpcClient.Call("KVService.Put", &request, &response)
+}
+
+func (c *client) commit(txid uint64, lead bool) (map[string]uint64, error) {
+	request := kvs.CommitRequest{Txid: txid, Lead: lead}
+	response := kvs.CommitResponse{}
+	err := c.rpcClient.Call("KVService.Commit", &request, &response)
+	return response.Versions, err
+}
+
+func (c *client) scan(startKey, endKey string, txid uint64) ([]string, []uint64, error) {
+	request := kvs.ScanRequest{StartKey: startKey, EndKey: endKey, Txid: txid}
+	response := kvs.ScanResponse{}
+	err := c.rpcClient.Call("KVService.Scan", &request, &response)
+	return response.Keys, response.Versions, err
+}
+
+func (c *client) abort(txid uint64) error {
+	request := kvs.AbortRequest{Txid: txid}
+	response := kvs.AbortResponse{}
+	return c.rpcClient.Call("KVService.Abort", &request, &response)
+}
@@ -0,0 +1,86 @@
+// stand up a single shard first:
+// $ go run ./kvs/server -port 8080
+// then
+// $ go test ./conformance -vectors=./testdata/vectors
+// keep in mind this modifies server state, so it's a good idea to restart
+// the server in between runs.
+package conformance
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	vectorsDir = flag.String("vectors", "./testdata/vectors", "Directory of conformance test vectors to run")
+	serverAddr = flag.String("server", "localhost:8080", "Shard to run vectors against (2PL mode, no -replicas)")
+)
+
+func TestConformance(t *testing.T) {
+	vectors, err := LoadVectors(*vectorsDir)
+	if err != nil {
+		t.Fatalf("loading vectors from %s: %v", *vectorsDir, err)
+	}
+	if len(vectors) == 0 {
+		t.Fatalf("no vectors found in %s", *vectorsDir)
+	}
+
+	c, err := dial(*serverAddr)
+	if err != nil {
+		t.Fatalf("dialing %s: %v (is a shard running? see this file's header)", *serverAddr, err)
+	}
+
+	for _, v := range vectors {
+		v := v
+		t.Run(v.Name, func(t *testing.T) {
+			checkVector(t, c, v)
+		})
+	}
+}
+
+// checkVector runs v's clients and asserts: every client's expected_commit
+// matches whether it actually ran to completion; the resulting history's
+// conflict-serializability matches v.Serializable(); every scan op's
+// result matches its schedule's ExpectScanKeys, if set; and (if
+// v.Invariant is set) that the invariant holds over the final state.
+func checkVector(t *testing.T, c *client, v Vector) {
+	result, err := runVector(c, v)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for i, sched := range v.Clients {
+		assert.Equal(t, sched.ExpectCommit, result.committed[i], "client %d commit outcome", i)
+		if sched.ExpectScanKeys != nil {
+			assert.Equal(t, sched.ExpectScanKeys, result.scannedKeys[i], "client %d scanned keys", i)
+		}
+	}
+
+	edges := buildConflictGraph(result.history, len(v.Clients))
+	cycle := detectCycle(edges)
+	if v.Serializable() {
+		if cycle != nil {
+			t.Errorf("history is not conflict-serializable: cycle among clients %v", cycle)
+		}
+	} else if cycle == nil {
+		t.Errorf("expected a known non-serializable history (expect_serializable: false), but found none - has this anomaly been fixed? update the vector if so")
+	}
+
+	if v.Invariant != nil {
+		txid := rand.Uint64()
+		var sum int64
+		for _, key := range v.Invariant.Keys {
+			value, _, err := c.get(key, txid)
+			assert.Nil(t, err)
+			n, _ := strconv.ParseInt(value, 10, 64)
+			sum += n
+		}
+		c.abort(txid)
+		assert.Equal(t, v.Invariant.Equal, sum, fmt.Sprintf("invariant over %v", v.Invariant.Keys))
+	}
+}
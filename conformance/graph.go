@@ -0,0 +1,153 @@
+package conformance
+
+import "sort"
+
+// readEvent/writeEvent record one observation made while replaying a
+// vector's clients, keyed by which ClientSchedule (by index) made it.
+type readEvent struct {
+	client  int
+	key     string
+	version uint64
+	// rangeEnd is set only on the marker event a "scan" adds alongside its
+	// per-key reads (see runSchedule): key/rangeEnd is the scanned
+	// [key, rangeEnd) range, standing for "no key in this range existed
+	// other than what was actually read" at this reader's snapshot. Empty
+	// for an ordinary "get"/scanned-key read event.
+	rangeEnd string
+}
+
+type writeEvent struct {
+	client  int
+	key     string
+	version uint64
+}
+
+// history is everything observed while running a vector's clients
+// concurrently: every Get's (key, version) and every committed Txn's
+// resulting (key, version) pairs (see client.commit's Versions return).
+type history struct {
+	reads  []readEvent
+	writes []writeEvent
+}
+
+// buildConflictGraph derives a standard multiversion conflict graph from a
+// history: a directed edge client A -> client B means A must precede B in
+// any equivalent serial order. Three edge kinds, one per key:
+//
+//   - write-write: consecutive writers of a key, in version order.
+//   - write-read: the writer of the version a read observed, to that
+//     reader.
+//   - read-write (anti-dependency): a reader, to whoever wrote the very
+//     next version of the key it read - that write is what made the
+//     reader's view stale.
+//
+// A cycle in this graph means the history isn't conflict-serializable (the
+// standard result behind tools like Cahill et al.'s serializable-snapshot-
+// isolation conflict check). See detectCycle.
+func buildConflictGraph(h history, numClients int) [][]bool {
+	edges := make([][]bool, numClients)
+	for i := range edges {
+		edges[i] = make([]bool, numClients)
+	}
+	addEdge := func(from, to int) {
+		if from != to && from >= 0 && to >= 0 {
+			edges[from][to] = true
+		}
+	}
+
+	writesByKey := make(map[string][]writeEvent)
+	for _, w := range h.writes {
+		writesByKey[w.key] = append(writesByKey[w.key], w)
+	}
+	for key := range writesByKey {
+		ws := writesByKey[key]
+		sort.Slice(ws, func(i, j int) bool { return ws[i].version < ws[j].version })
+		for i := 1; i < len(ws); i++ {
+			addEdge(ws[i-1].client, ws[i].client) // write-write
+		}
+		for _, r := range h.reads {
+			if r.key != key || r.version == 0 {
+				continue // version 0 means the key didn't exist yet - no tracked writer to link to
+			}
+			for _, w := range ws {
+				if w.version == r.version {
+					addEdge(w.client, r.client) // write-read
+				}
+				if w.version == r.version+1 {
+					addEdge(r.client, w.client) // read-write anti-dependency
+				}
+			}
+		}
+	}
+
+	// Phantom via range: a scan's marker read event (rangeEnd set) stands
+	// for "no key in [key, rangeEnd) existed yet, beyond what the scan
+	// actually returned" - so a write that inserts a brand new key
+	// (version == 1) into that range is anti-dependent on the scan, the
+	// same as the single-key read-write case above, even though the scan
+	// never read that exact key.
+	for _, r := range h.reads {
+		if r.rangeEnd == "" {
+			continue
+		}
+		for _, w := range h.writes {
+			if w.version == 1 && r.key <= w.key && w.key < r.rangeEnd {
+				addEdge(r.client, w.client)
+			}
+		}
+	}
+
+	return edges
+}
+
+// detectCycle runs a standard 3-color DFS over edges and returns the first
+// cycle found (as a list of client indices), or nil if the graph is
+// acyclic.
+func detectCycle(edges [][]bool) []int {
+	const (
+		white = iota
+		gray
+		black
+	)
+	n := len(edges)
+	color := make([]int, n)
+	parent := make([]int, n)
+	var cycleAt = -1
+
+	var dfs func(u int) bool
+	dfs = func(u int) bool {
+		color[u] = gray
+		for v := 0; v < n; v++ {
+			if !edges[u][v] {
+				continue
+			}
+			if color[v] == gray {
+				cycleAt = v
+				parent[v] = u
+				return true
+			}
+			if color[v] == white {
+				parent[v] = u
+				if dfs(v) {
+					return true
+				}
+			}
+		}
+		color[u] = black
+		return false
+	}
+
+	for start := 0; start < n; start++ {
+		if color[start] != white {
+			continue
+		}
+		if dfs(start) {
+			cycle := []int{cycleAt}
+			for cur := parent[cycleAt]; cur != cycleAt; cur = parent[cur] {
+				cycle = append(cycle, cur)
+			}
+			return cycle
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package conformance
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+)
+
+// barrier blocks every caller until target callers have all called wait,
+// so a vector can force a specific concurrent interleaving (e.g. "both
+// clients must have read x before either writes it") deterministically
+// instead of hoping goroutine scheduling happens to race them.
+type barrier struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	target  int
+	arrived int
+}
+
+func newBarrier(target int) *barrier {
+	b := &barrier{target: target}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *barrier) wait() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.arrived++
+	if b.arrived >= b.target {
+		b.cond.Broadcast()
+		return
+	}
+	for b.arrived < b.target {
+		b.cond.Wait()
+	}
+}
+
+// clientResult is what running one ClientSchedule produced.
+type clientResult struct {
+	committed   bool
+	reads       []readEvent
+	writes      []writeEvent
+	scannedKeys []string
+}
+
+// barriersFor collects every barrier name a vector's clients reference and
+// builds one barrier per name, sized to how many schedules wait on it.
+func barriersFor(clients []ClientSchedule) map[string]*barrier {
+	counts := make(map[string]int)
+	for _, sched := range clients {
+		seen := make(map[string]bool)
+		for _, op := range sched.Ops {
+			if op.Op == "barrier" && !seen[op.Name] {
+				counts[op.Name]++
+				seen[op.Name] = true
+			}
+		}
+	}
+	barriers := make(map[string]*barrier, len(counts))
+	for name, n := range counts {
+		barriers[name] = newBarrier(n)
+	}
+	return barriers
+}
+
+// runSchedule replays one client's ops over a single fresh txid, reporting
+// whether it ran to completion (every op and the Commit both succeeded)
+// along with every read/write/scan it made. Once any op aborts the Txn,
+// later "get"/"put"/"incrput"/"scan" ops are skipped - but "barrier" ops
+// still run, so a client this one is synchronizing with doesn't hang
+// waiting for an arrival that will never come just because this schedule
+// hit a conflict first.
+func runSchedule(c *client, idx int, sched ClientSchedule, barriers map[string]*barrier) clientResult {
+	txid := rand.Uint64()
+	result := clientResult{}
+	aborted := false
+
+	values := make(map[string]int64) // this txn's own view of keys it's touched via "get"/"incrput"
+
+	for _, op := range sched.Ops {
+		if op.Op == "barrier" {
+			barriers[op.Name].wait()
+			continue
+		}
+		if aborted {
+			continue
+		}
+
+		switch op.Op {
+		case "get":
+			value, version, err := c.get(op.Key, txid)
+			if err != nil {
+				c.abort(txid)
+				aborted = true
+				continue
+			}
+			result.reads = append(result.reads, readEvent{client: idx, key: op.Key, version: version})
+			n, _ := strconv.ParseInt(value, 10, 64) // missing key reads as "", parses to 0 - treated as a starting balance of 0
+			values[op.Key] = n
+
+		case "put":
+			if err := c.put(op.Key, op.Value, txid); err != nil {
+				c.abort(txid)
+				aborted = true
+			}
+
+		case "incrput":
+			// Writes back values[op.Key] + op.Delta, i.e. whatever a prior
+			// "get" of this key in this schedule last observed, plus
+			// Delta - a read-modify-write pattern built from two separate
+			// RPCs (not a single atomic op), so a "barrier" between the
+			// "get" and this can force it to race a concurrent writer of
+			// the same key. testdata/vectors/lost_update.json instead puts
+			// its barrier after the first client's incrput, so the second
+			// client's "get" runs while that write lock is still held.
+			n := values[op.Key] + op.Delta
+			values[op.Key] = n
+			if err := c.put(op.Key, strconv.FormatInt(n, 10), txid); err != nil {
+				c.abort(txid)
+				aborted = true
+			}
+
+		case "scan":
+			keys, versions, err := c.scan(op.Key, op.Value, txid)
+			if err != nil {
+				c.abort(txid)
+				aborted = true
+				continue
+			}
+			result.scannedKeys = keys
+			for i, key := range keys {
+				result.reads = append(result.reads, readEvent{client: idx, key: key, version: versions[i]})
+			}
+			// Marker for the queried range itself (see readEvent.rangeEnd),
+			// so buildConflictGraph can catch a phantom insert into it even
+			// though the scan never read that key directly.
+			result.reads = append(result.reads, readEvent{client: idx, key: op.Key, rangeEnd: op.Value})
+		}
+	}
+
+	if aborted {
+		return result
+	}
+
+	versions, err := c.commit(txid, true)
+	if err != nil {
+		return result
+	}
+	for key, version := range versions {
+		result.writes = append(result.writes, writeEvent{client: idx, key: key, version: version})
+	}
+	result.committed = true
+	return result
+}
+
+// vectorResult is runVector's return value: the merged history (for
+// buildConflictGraph) plus, per client schedule index, whether it
+// committed and what its "scan" op (if any) returned.
+type vectorResult struct {
+	history     history
+	committed   []bool
+	scannedKeys [][]string
+}
+
+// runVector applies a vector's initial_state, then runs every client
+// schedule concurrently.
+func runVector(c *client, v Vector) (vectorResult, error) {
+	for key, value := range v.InitialState {
+		txid := rand.Uint64()
+		if err := c.put(key, value, txid); err != nil {
+			return vectorResult{}, fmt.Errorf("seeding initial_state[%s]: %w", key, err)
+		}
+		if _, err := c.commit(txid, true); err != nil {
+			return vectorResult{}, fmt.Errorf("committing initial_state[%s]: %w", key, err)
+		}
+	}
+
+	barriers := barriersFor(v.Clients)
+	results := make([]clientResult, len(v.Clients))
+	var wg sync.WaitGroup
+	for i, sched := range v.Clients {
+		i, sched := i, sched
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runSchedule(c, i, sched, barriers)
+		}()
+	}
+	wg.Wait()
+
+	out := vectorResult{
+		committed:   make([]bool, len(results)),
+		scannedKeys: make([][]string, len(results)),
+	}
+	for i, r := range results {
+		out.history.reads = append(out.history.reads, r.reads...)
+		out.history.writes = append(out.history.writes, r.writes...)
+		out.committed[i] = r.committed
+		out.scannedKeys[i] = r.scannedKeys
+	}
+	return out, nil
+}
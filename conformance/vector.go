@@ -0,0 +1,111 @@
+// Package conformance drives JSON transaction-schedule vectors against a
+// running shard (see kvs/server) and checks the resulting history for
+// conflict-serializability, instead of relying on the probabilistic xfer
+// workload in kvs/client/main.go to shake out locking/2PC regressions.
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Op is one step of a client's schedule. Op is one of:
+//   - "get": read Key, recording the version it observed and parsing its
+//     value as a base-10 integer (a missing key reads as 0) for a later
+//     "incrput" of the same Key to build on.
+//   - "put": write Value to Key unconditionally.
+//   - "incrput": write Key's last "get" result plus Delta back to Key - a
+//     read-modify-write client pattern built from two separate RPCs
+//     (unlike "put"), so a "barrier" between the "get" and this can force
+//     it to race a concurrent writer of the same key.
+//   - "scan": range-scan [Key, Value) (reusing those fields as start/end,
+//     since a scan has no single key of its own) and record the returned
+//     keys, for a later assertion against ClientSchedule.ExpectScanKeys.
+//   - "barrier": block until every client schedule in this vector that
+//     references the same Name has also reached a "barrier" op with that
+//     Name. Used to force a specific interleaving deterministically,
+//     instead of hoping goroutine scheduling happens to race two clients.
+type Op struct {
+	Op    string `json:"op"`
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+	Delta int64  `json:"delta,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
+// ClientSchedule is one simulated client's transaction: Ops run in order
+// inside a single Txn, followed by a Commit (skipped if an op already
+// aborted the Txn). ExpectCommit says whether that Txn is expected to
+// finish (every op and the Commit itself succeed) without hitting a lock
+// conflict/timeout/wound. ExpectScanKeys, if set, is checked against the
+// keys returned by this schedule's (single) "scan" op.
+type ClientSchedule struct {
+	Ops            []Op     `json:"ops"`
+	ExpectCommit   bool     `json:"expected_commit"`
+	ExpectScanKeys []string `json:"expected_scan_keys,omitempty"`
+}
+
+// Invariant checks that, after every client schedule has finished, the sum
+// of Keys' final values equals Equal - e.g. the classic bank-transfer
+// invariant that no transfer ever changes the total held across accounts.
+type Invariant struct {
+	Keys  []string `json:"keys"`
+	Equal int64    `json:"equal"`
+}
+
+// Vector is one conformance test-vector: a starting key-value state, a set
+// of clients to run concurrently against it, and what the resulting
+// history should look like. ExpectSerializable defaults to true: this
+// system's locks cover reads as well as writes (held until commit, not just
+// released at a snapshot point), so even the classic write-skew/G2 setups -
+// two transactions reading overlapping keys, each then writing a key the
+// other only read - don't actually slip through here; the older
+// transaction's write wounds the younger reader, so only one side commits
+// (see testdata/vectors/write_skew.json and g2.json). Set it false for a
+// vector documenting a gap this locking genuinely doesn't cover. No vector
+// in testdata/vectors sets it false: phantom_range.json shows that a scan
+// locks keys, not the range itself (the insert commits alongside the scan),
+// but that history is still serializable with the scanner ordered first.
+type Vector struct {
+	Name               string            `json:"name"`
+	InitialState       map[string]string `json:"initial_state"`
+	Clients            []ClientSchedule  `json:"clients"`
+	Invariant          *Invariant        `json:"invariant,omitempty"`
+	ExpectSerializable *bool             `json:"expect_serializable,omitempty"`
+}
+
+// Serializable reports whether v expects its history to come out
+// conflict-serializable (the default, absent an explicit false).
+func (v Vector) Serializable() bool {
+	return v.ExpectSerializable == nil || *v.ExpectSerializable
+}
+
+// LoadVectors reads every *.json file in dir as a Vector, sorted by
+// filename so a run's output order is stable.
+func LoadVectors(dir string) ([]Vector, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	var vectors []Vector
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		var v Vector
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if v.Name == "" {
+			v.Name = filepath.Base(path)
+		}
+		vectors = append(vectors, v)
+	}
+	return vectors, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/rstutsman/cs6450-labs/kvs"
+)
+
+// BatchPut buffers multiple writes on one shard in a single RPC, each under
+// a write lock the server records in the transaction's op log. See
+// Txn.BatchPut for the per-shard fan-out that calls this.
+func (client *Client) BatchPut(keys []string, values []string, txid uint64) error {
+	request := kvs.BatchPutRequest{Keys: keys, Values: values, Txid: txid}
+	response := kvs.BatchPutResponse{}
+	err := client.call("KVService.BatchPut", &request, &response)
+	if err != nil {
+		log.Printf("Error during Client.BatchPut: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Scan asks one shard for up to limit keys in [startKey, endKey), each
+// under a read lock the server records in the transaction's op log. See
+// Txn.Scan for the fan-out across every shard that calls this.
+func (client *Client) Scan(startKey string, endKey string, limit int, txid uint64) ([]string, []string, error) {
+	request := kvs.ScanRequest{StartKey: startKey, EndKey: endKey, Limit: limit, Txid: txid}
+	response := kvs.ScanResponse{}
+	err := client.call("KVService.Scan", &request, &response)
+	if err != nil {
+		log.Printf("Error during Client.Scan: %v", err)
+		return nil, nil, err
+	}
+	return response.Keys, response.Values, nil
+}
+
+// BatchPut writes every pair in one round trip per shard - each shard
+// touched by pairs gets a single locked BatchPut RPC carrying just the keys
+// it owns, run concurrently, instead of one Put per key. Like Put, values
+// are only buffered here; they're applied on Commit.
+func (txn *Txn) BatchPut(pairs map[string]string) error {
+	if txn.id == nil {
+		return errors.New("cannot call BatchPut on a transaction that has not begun")
+	}
+
+	byServer := make(map[*Client][]string)
+	for key := range pairs {
+		server := txn.getServer(key)
+		byServer[server] = append(byServer[server], key)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	for server, serverKeys := range byServer {
+		serverValues := make([]string, len(serverKeys))
+		for i, key := range serverKeys {
+			serverValues[i] = pairs[key]
+		}
+		wg.Add(1)
+		go func(server *Client, serverKeys, serverValues []string) {
+			defer wg.Done()
+			if err := server.BatchPut(serverKeys, serverValues, *txn.id); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(server, serverKeys, serverValues)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		classified := kvs.ClassifyError(firstErr)
+		if errors.Is(classified, kvs.ErrFatal) {
+			_ = txn.Abort()
+		}
+		return classified
+	}
+
+	for key, value := range pairs {
+		txn.writeSet[key] = value
+	}
+	return nil
+}
+
+// Scan returns up to limit keys in [startKey, endKey). Shards are assigned
+// by hash of key (see serverFromKey), not by key order, so a single logical
+// range generally spans every shard; Scan fans out to all of them in
+// parallel and merges the results, applying limit to the combined output
+// since each shard only knows how to bound its own share of the range.
+func (txn *Txn) Scan(startKey string, endKey string, limit int) (map[string]string, error) {
+	if txn.id == nil {
+		return nil, errors.New("cannot call Scan on a transaction that has not begun")
+	}
+
+	type shardResult struct {
+		keys   []string
+		values []string
+		err    error
+	}
+
+	results := make([]shardResult, len(txn.allServers))
+	var wg sync.WaitGroup
+	for i, server := range txn.allServers {
+		wg.Add(1)
+		go func(i int, server *Client) {
+			defer wg.Done()
+			keys, values, err := server.Scan(startKey, endKey, limit, *txn.id)
+			results[i] = shardResult{keys: keys, values: values, err: err}
+		}(i, server)
+	}
+	wg.Wait()
+
+	merged := make(map[string]string)
+	for i, r := range results {
+		if r.err != nil {
+			classified := kvs.ClassifyError(r.err)
+			if errors.Is(classified, kvs.ErrFatal) {
+				_ = txn.Abort()
+			}
+			return nil, classified
+		}
+		if len(r.keys) > 0 {
+			server := txn.allServers[i]
+			if !txn.usedServers.Contains(server) {
+				txn.participants = append(txn.participants, server)
+			}
+			txn.usedServers.Add(server)
+		}
+		for j, key := range r.keys {
+			merged[key] = r.values[j]
+		}
+	}
+
+	if limit > 0 && len(merged) > limit {
+		keys := make([]string, 0, len(merged))
+		for k := range merged {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		trimmed := make(map[string]string, limit)
+		for _, k := range keys[:limit] {
+			trimmed[k] = merged[k]
+		}
+		return trimmed, nil
+	}
+
+	return merged, nil
+}
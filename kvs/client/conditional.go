@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/rstutsman/cs6450-labs/kvs"
+)
+
+// ConditionalCommit sends a one-shot If/Then/Else transaction to the server
+// that owns its Cmps/ops (see Txn.If). Callers normally go through
+// CondTxn.Commit rather than this directly.
+func (client *Client) ConditionalCommit(request *kvs.ConditionalCommitRequest) (*kvs.ConditionalCommitResponse, error) {
+	response := kvs.ConditionalCommitResponse{}
+	err := client.call("KVService.ConditionalCommit", request, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// CmpValue builds a Compare predicate over a key's current value.
+func CmpValue(key string, op kvs.CompareOp, value string) kvs.Compare {
+	return kvs.Compare{Key: key, Target: kvs.CompareValue, Op: op, Value: value}
+}
+
+// CmpVersion builds a Compare predicate over a key's version counter, bumped
+// on every commit that writes it - the building block for optimistic CAS.
+func CmpVersion(key string, op kvs.CompareOp, version uint64) kvs.Compare {
+	return kvs.Compare{Key: key, Target: kvs.CompareVersion, Op: op, Version: version}
+}
+
+// CmpExists builds a Compare predicate over whether a key currently exists.
+func CmpExists(key string, exists bool) kvs.Compare {
+	return kvs.Compare{Key: key, Target: kvs.CompareExists, Exists: exists}
+}
+
+// OpPut builds a ConditionalOp for use in CondTxn.Then/Else.
+func OpPut(key string, value string) kvs.ConditionalOp {
+	return kvs.ConditionalOp{OpType: "PUT", Key: key, Value: value}
+}
+
+// CondTxn is an etcd-style conditional transaction: txn.If(cmps...).Then(ops...).Else(ops...).Commit().
+// Unlike Txn, it evaluates and applies in a single RPC against the server
+// that owns its first Cmp's key, so every Cmp/op here must target that same
+// shard - this is meant for single-key CAS idioms, not cross-shard commits.
+type CondTxn struct {
+	txn  *Txn
+	cmps []kvs.Compare
+	then []kvs.ConditionalOp
+	els  []kvs.ConditionalOp
+}
+
+// If starts a conditional transaction scoped to txn's already-begun id.
+func (txn *Txn) If(cmps ...kvs.Compare) *CondTxn {
+	return &CondTxn{txn: txn, cmps: cmps}
+}
+
+func (c *CondTxn) Then(ops ...kvs.ConditionalOp) *CondTxn {
+	c.then = ops
+	return c
+}
+
+func (c *CondTxn) Else(ops ...kvs.ConditionalOp) *CondTxn {
+	c.els = ops
+	return c
+}
+
+// Commit evaluates the predicates and applies whichever branch they select,
+// returning which branch ran (true == Then) and the values observed for
+// every key referenced by a Cmp.
+func (c *CondTxn) Commit() (bool, map[string]string, error) {
+	if c.txn.id == nil {
+		return false, nil, errors.New("cannot commit a conditional transaction whose Txn has not begun")
+	}
+	if len(c.cmps) == 0 {
+		return false, nil, errors.New("conditional transaction requires at least one If predicate")
+	}
+
+	server := c.txn.getServer(c.cmps[0].Key)
+	request := kvs.ConditionalCommitRequest{
+		Txid: *c.txn.id,
+		Cmps: c.cmps,
+		Then: c.then,
+		Else: c.els,
+	}
+	response, err := server.ConditionalCommit(&request)
+	if err != nil {
+		return false, nil, err
+	}
+	return response.Succeeded, response.Values, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+
+	"github.com/rstutsman/cs6450-labs/kvs"
+)
+
+// coordinatorLog durably records this client process's 2PC decisions (see
+// Txn.Commit/logDecision) so a crash between deciding a transaction and
+// finishing phase two can be recovered from on restart (see
+// recoverCoordinatorLog). main() always opens it (-coordinator-log defaults
+// to coordinator.log, and a failure to open it is fatal), so it is only
+// left nil - and logging silently skipped - in the test harness, which
+// never runs main().
+var coordinatorLog *kvs.CoordinatorLog
+
+// recoverCoordinatorLog replays every decision this process ever durably
+// logged and re-sends it to every participant, so a transaction whose
+// coordinator crashed mid-phase-two gets finished rather than leaving its
+// participants to fall back on the slower resolvePreparedTxn polling path
+// (kvs/server/main.go). Re-sending a decision that already landed is a
+// no-op on the server side (Commit/Abort on an already-decided or
+// already-cleaned-up txid just updates kv.decisions again), so replaying
+// the whole log unconditionally on every startup is safe, if not maximally
+// efficient - the same simplicity tradeoff kvs/server/main.go's
+// recoverFromWAL makes.
+func recoverCoordinatorLog(coordLog *kvs.CoordinatorLog) {
+	records, err := coordLog.ReadAll()
+	if err != nil {
+		log.Printf("coordinator log recovery: failed to read log: %v", err)
+		return
+	}
+
+	for _, record := range records {
+		for _, addr := range record.Participants {
+			server := Dial(addr)
+			lead := addr == record.PrimaryAddr
+			switch record.Decision {
+			case "COMMIT":
+				request := kvs.CommitRequest{Txid: record.Txid, Lead: lead, ClientId: server.clientId, SeqNum: server.nextSeqNum()}
+				response := kvs.CommitResponse{}
+				if err := server.call("KVService.Commit", &request, &response); err != nil {
+					log.Printf("coordinator log recovery: resending COMMIT for txn %d to %s: %v", record.Txid, addr, err)
+				}
+			case "ABORT":
+				request := kvs.AbortRequest{Txid: record.Txid, ClientId: server.clientId, SeqNum: server.nextSeqNum()}
+				response := kvs.AbortResponse{}
+				if err := server.call("KVService.Abort", &request, &response); err != nil {
+					log.Printf("coordinator log recovery: resending ABORT for txn %d to %s: %v", record.Txid, addr, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rstutsman/cs6450-labs/kvs"
+)
+
+// deadlockDetectInterval is how often the background detector polls every
+// active transaction's participants for waits-for edges. Comfortably under
+// defaultLockTimeout (kvs/server/lockmanager.go), so a genuine cross-shard
+// cycle gets broken well before its members would otherwise just time out
+// on their own - and well above a normal RPC round trip, so the poll
+// itself doesn't become a meaningful source of load.
+const deadlockDetectInterval = 200 * time.Millisecond
+
+// activeTxns tracks every transaction currently in flight in this process,
+// keyed by Txid, so startDeadlockDetector can poll their participants'
+// WaitingOn RPC and assemble a waits-for graph spanning every shard they
+// touched. A single shard's wound-wait (see kvs/server/lockmanager.go's
+// ageOf) only orders ages locally, so two transactions can each wait on
+// the other across different shards without either shard ever seeing a
+// cycle on its own.
+var activeTxns sync.Map // map[uint64]*Txn
+
+// startDeadlockDetector launches the background poller; call once per
+// process (see main()). It's purely a liveness optimization, not a
+// correctness requirement - every wait still has defaultLockTimeout as a
+// backstop - so skipping it (as the test harness does) just means a
+// cross-shard cycle resolves by timeout instead of being broken early.
+func startDeadlockDetector(done *atomic.Bool) {
+	go func() {
+		for !done.Load() {
+			time.Sleep(deadlockDetectInterval)
+			detectAndBreakDeadlocks()
+		}
+	}()
+}
+
+// detectAndBreakDeadlocks polls WaitingOn on every participant of every
+// transaction this process currently has in flight, builds the resulting
+// waits-for graph, and - if it contains a cycle - kills the lowest-Txid
+// member to break it. The victim is chosen by Txid alone, not by the
+// first-seen ages that ageOf/wound use within a single shard.
+func detectAndBreakDeadlocks() {
+	waitsFor := map[uint64]map[uint64]bool{}
+	owners := map[uint64]*Txn{}
+
+	activeTxns.Range(func(k, v interface{}) bool {
+		txid := k.(uint64)
+		txn := v.(*Txn)
+		owners[txid] = txn
+
+		for _, server := range txn.participants {
+			request := kvs.WaitingOnRequest{Txid: txid}
+			response := kvs.WaitingOnResponse{}
+			if err := server.call("KVService.WaitingOn", &request, &response); err != nil {
+				continue
+			}
+			for _, other := range response.WaitingOn {
+				if waitsFor[txid] == nil {
+					waitsFor[txid] = map[uint64]bool{}
+				}
+				waitsFor[txid][other] = true
+			}
+		}
+		return true
+	})
+
+	cycle := findCycle(waitsFor)
+	if cycle == nil {
+		return
+	}
+
+	victim := cycle[0]
+	for _, txid := range cycle[1:] {
+		if txid < victim {
+			victim = txid
+		}
+	}
+	killTxn(victim, owners)
+}
+
+// findCycle runs a 3-color DFS over waitsFor (txid -> set of txids it's
+// currently blocked behind) and returns one cycle, or nil if the graph is
+// acyclic - the same technique conformance/graph.go uses to check a
+// finished history for conflict-serializability, applied here to live
+// wait edges instead.
+func findCycle(waitsFor map[uint64]map[uint64]bool) []uint64 {
+	const (
+		white = iota
+		gray
+		black
+	)
+	color := map[uint64]int{}
+	parent := map[uint64]uint64{}
+	var cycleAt uint64
+	found := false
+
+	var dfs func(u uint64) bool
+	dfs = func(u uint64) bool {
+		color[u] = gray
+		for v := range waitsFor[u] {
+			if color[v] == gray {
+				cycleAt = v
+				parent[v] = u
+				return true
+			}
+			if color[v] == white {
+				parent[v] = u
+				if dfs(v) {
+					return true
+				}
+			}
+		}
+		color[u] = black
+		return false
+	}
+
+	for start := range waitsFor {
+		if color[start] == white && dfs(start) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	cycle := []uint64{cycleAt}
+	for cur := parent[cycleAt]; cur != cycleAt; cur = parent[cur] {
+		cycle = append(cycle, cur)
+	}
+	return cycle
+}
+
+// killTxn sends KillDeadlockVictimRequest to every shard victim's owning
+// Txn knows about. owners only has entries for transactions this process
+// itself started (activeTxns is per-process), so a victim that belongs to
+// another process's Txn - one we only ever saw as an edge target in
+// someone else's WaitingOn response - is silently left alone; it'll still
+// resolve via its own shard's lockTimeout.
+func killTxn(victim uint64, owners map[uint64]*Txn) {
+	txn, ok := owners[victim]
+	if !ok {
+		return
+	}
+	request := kvs.KillDeadlockVictimRequest{Txid: victim}
+	for _, server := range txn.allServers {
+		response := kvs.KillDeadlockVictimResponse{}
+		_ = server.call("KVService.KillAsDeadlockVictim", &request, &response)
+	}
+}
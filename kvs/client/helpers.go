@@ -1,11 +1,17 @@
 package main
 
-import "hash/fnv"
+import (
+	"hash/fnv"
+	"strings"
+)
 
+// dialHosts connects to every shard in servers. A shard token may name a
+// single host:port, or a Raft-replicated group as "host1:port+host2:port+...";
+// see DialGroup and -replicas in kvs/server/main.go.
 func dialHosts(servers HostList) []*Client { // HostList is []string, convert it to []*Client
 	var clients []*Client
-	for _, addr := range servers {
-		clients = append(clients, Dial(addr))
+	for _, shard := range servers {
+		clients = append(clients, DialGroup(strings.Split(shard, "+")))
 	}
 	return clients
 }
@@ -10,6 +10,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -18,26 +19,118 @@ import (
 
 var randGen = rand.New(rand.NewSource(time.Now().UnixNano()))
 
+// raftRetryBackoff is how long Client.call waits before trying the next
+// replica in a shard's group after ErrWrongLeader or a transport failure.
+const raftRetryBackoff = 20 * time.Millisecond
+
 type Client struct {
-	rpcClient *rpc.Client
+	mu    sync.Mutex
+	addrs []string      // this shard's replica group; len 1 outside -replicas mode
+	conns []*rpc.Client // lazily dialed, parallel to addrs
+
+	addr string // addrs[0]; host:port this client dialed, needed to tell other shards who the 2PC primary is
+
+	leaderIdx int32  // cached guess at which addrs[i] currently leads this shard's Raft group
+	clientId  uint64 // random id stamped on Get/Put/Commit/Abort for server-side duplicate detection
+	seqNum    uint64 // atomic; next sequence number to stamp an RPC with (see kvs.PutRequest.SeqNum)
 }
 
+// Dial connects to a single, non-replicated shard at addr.
 func Dial(addr string) *Client {
-	rpcClient, err := rpc.DialHTTP("tcp", addr) // addr ex: "localhost:8080"
-	if err != nil {
+	return DialGroup([]string{addr})
+}
+
+// DialGroup connects to a shard that may be replicated across a Raft group
+// (see kvs/raftkv): addrs[0] is dialed eagerly, matching Dial's original
+// fail-fast behavior; the rest are dialed lazily by connAt. Get/Put/Commit/
+// Abort retry across the group on ErrWrongLeader or a transport failure (see
+// call), caching whichever address answers as the new leader guess.
+func DialGroup(addrs []string) *Client {
+	client := &Client{
+		addrs:    addrs,
+		conns:    make([]*rpc.Client, len(addrs)),
+		addr:     addrs[0],
+		clientId: randGen.Uint64(),
+	}
+	if _, err := client.connAt(0); err != nil {
 		log.Fatal(err) // os.Exit(1) called
 	}
+	return client
+}
+
+// connAt lazily dials addrs[i], reusing the connection on later calls.
+func (client *Client) connAt(i int) (*rpc.Client, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.conns[i] != nil {
+		return client.conns[i], nil
+	}
+	conn, err := rpc.DialHTTP("tcp", client.addrs[i])
+	if err != nil {
+		return nil, err
+	}
+	client.conns[i] = conn
+	return conn, nil
+}
+
+// call issues one RPC against this shard's replica group, trying the cached
+// leader guess first. ErrWrongLeader or a transport failure (the replica is
+// down, or unreachable) rotates to the next replica in the group - up to
+// once around - with a small backoff between attempts, caching whichever
+// replica answers as the new leader guess. Any other error (a lock
+// conflict, say) is returned immediately without rotating, preserving the
+// direct rpcClient.Call error semantics the rest of this file relies on.
+// Outside replicated mode (len(addrs) == 1) this degenerates to that direct
+// call.
+func (client *Client) call(serviceMethod string, args interface{}, reply interface{}) error {
+	n := len(client.addrs)
+	start := int(atomic.LoadInt32(&client.leaderIdx))
+
+	var lastErr error
+	for attempt := 0; attempt < n; attempt++ {
+		i := (start + attempt) % n
+
+		conn, err := client.connAt(i)
+		if err != nil {
+			lastErr = err
+			if attempt < n-1 {
+				time.Sleep(raftRetryBackoff)
+			}
+			continue
+		}
+
+		err = conn.Call(serviceMethod, args, reply)
+		if err == nil {
+			atomic.StoreInt32(&client.leaderIdx, int32(i))
+			return nil
+		}
+		if err != rpc.ErrShutdown && !strings.Contains(err.Error(), "ErrWrongLeader") {
+			return err
+		}
+		lastErr = err
+		if attempt < n-1 {
+			time.Sleep(raftRetryBackoff)
+		}
+	}
+	return lastErr
+}
 
-	return &Client{rpcClient} // wrap rpcClient to our Client struct
+// nextSeqNum returns the next sequence number to stamp a Get/Put/Commit/
+// Abort RPC with - see kvs.PutRequest.ClientId/SeqNum.
+func (client *Client) nextSeqNum() uint64 {
+	return atomic.AddUint64(&client.seqNum, 1)
 }
 
 func (client *Client) Get(key string, txid uint64) (string, error) {
 	request := kvs.GetRequest{
-		Key:  key,
-		Txid: txid,
+		Key:      key,
+		Txid:     txid,
+		ClientId: client.clientId,
+		SeqNum:   client.nextSeqNum(),
 	}
 	response := kvs.GetResponse{}
-	err := client.rpcClient.Call("KVService.Get", &request, &response)
+	err := client.call("KVService.Get", &request, &response)
 	if err != nil {
 		log.Printf("Error during Client.Get: %v", err)
 		return "", err
@@ -46,14 +139,56 @@ func (client *Client) Get(key string, txid uint64) (string, error) {
 	return response.Value, nil
 }
 
+// BatchGet reads multiple keys from one shard in a single RPC, each under a
+// read lock the server records in the transaction's op log. See
+// readonly.go's Txn.BatchGet for the per-shard fan-out that calls this.
+func (client *Client) BatchGet(keys []string, txid uint64) ([]string, error) {
+	request := kvs.BatchGetRequest{Keys: keys, Txid: txid}
+	response := kvs.BatchGetResponse{}
+	err := client.call("KVService.BatchGet", &request, &response)
+	if err != nil {
+		log.Printf("Error during Client.BatchGet: %v", err)
+		return nil, err
+	}
+	return response.Values, nil
+}
+
+// Prepare sends phase one of 2PC: the participant durably records its
+// buffered ops for txid and votes yes/no. primaryAddr tells it who to ask
+// for the final decision if this transaction's coordinator disappears
+// before phase two (see Txn.Commit and kvs/server/main.go's recoverFromWAL).
+func (client *Client) Prepare(txid uint64, primaryAddr string) (bool, error) {
+	request := kvs.PrepareRequest{Txid: txid, PrimaryAddr: primaryAddr}
+	response := kvs.PrepareResponse{}
+	err := client.call("KVService.Prepare", &request, &response)
+	if err != nil {
+		return false, err
+	}
+	return response.Vote, nil
+}
+
+// Status asks this shard (normally a 2PC primary) for a transaction's final
+// decision.
+func (client *Client) Status(txid uint64) (string, error) {
+	request := kvs.StatusRequest{Txid: txid}
+	response := kvs.StatusResponse{}
+	err := client.call("KVService.Status", &request, &response)
+	if err != nil {
+		return "", err
+	}
+	return response.Decision, nil
+}
+
 func (client *Client) Put(key string, value string, txid uint64) error {
 	request := kvs.PutRequest{
-		Key:   key,
-		Value: value,
-		Txid:  txid,
+		Key:      key,
+		Value:    value,
+		Txid:     txid,
+		ClientId: client.clientId,
+		SeqNum:   client.nextSeqNum(),
 	}
 	response := kvs.PutResponse{}
-	err := client.rpcClient.Call("KVService.Put", &request, &response) // only return err (if exists)
+	err := client.call("KVService.Put", &request, &response) // only return err (if exists)
 	if err != nil {
 		log.Printf("Error during Client.Put: %v", err)
 		return err
@@ -62,10 +197,12 @@ func (client *Client) Put(key string, value string, txid uint64) error {
 }
 
 type Txn struct {
-	allServers  []*Client         // For server := serverFromKey(&key, txn.allServers)
-	usedServers *Set[*Client]     // For notifying all participated server when Commit/Abort
-	id          *uint64           // Zero value is nil i/o 0. Prevent being unable to distinguish between "uninitialized" and "ID is 0"
-	writeSet    map[string]string // Keep write set cache to avoid unnecessary requests
+	allServers   []*Client         // For server := serverFromKey(&key, txn.allServers)
+	usedServers  *Set[*Client]     // For notifying all participated server when Commit/Abort
+	participants []*Client         // usedServers in first-touched order, so every replica of this txn agrees on a primary (see electPrimary)
+	id           *uint64           // Zero value is nil i/o 0. Prevent being unable to distinguish between "uninitialized" and "ID is 0"
+	writeSet     map[string]string // Keep write set cache to avoid unnecessary requests
+	readOnly     bool              // set by ReadOnly(); routes Get/BatchGet through the lock-free fast path (see readonly.go)
 }
 
 func (txn *Txn) Begin(availableServers []*Client) {
@@ -73,42 +210,137 @@ func (txn *Txn) Begin(availableServers []*Client) {
 	id := randGen.Uint64() // Global: var randGen = rand.New(rand.NewSource(time.Now().UnixNano()))
 	txn.id = &id
 	txn.usedServers = NewSet[*Client]() // // NewSet[T comparable]() -> *Set[T]
+	txn.participants = nil
 	txn.writeSet = make(map[string]string)
+	txn.readOnly = false
 	// Can be called multiple times, but the transaction will be reset
+
+	activeTxns.Store(id, txn) // see deadlock.go; removed again by Commit/Abort
 }
 
+// electPrimary picks the shard that anchors this transaction's commit
+// decision by hashing the txid over participants (in first-touched order),
+// matching the CommitRequest.Lead hint the original single-phase Commit
+// used. Participants don't derive it themselves: Commit sends the primary's
+// address to each of them in Prepare, and a server recovering from a crash
+// reads it back from its PREPARE record.
+func (txn *Txn) electPrimary() *Client {
+	return txn.participants[*txn.id%uint64(len(txn.participants))]
+}
+
+// Commit runs real two-phase commit across every shard this transaction
+// touched: phase one (Prepare) durably records each participant's buffered
+// ops and collects a yes/no vote; once every vote is in, the decision is
+// durably appended to this process's own coordinatorLog (see
+// kvs/coordinatorlog.go) *before* phase two sends Commit to the elected
+// primary first (so its WAL becomes the transaction's source of truth) and
+// then to the rest. Logging the decision first means a coordinator that
+// crashes mid-phase-two can replay coordinatorLog on restart and finish
+// delivering it (see recoverCoordinatorLog) instead of leaving participants
+// to fall back on the slower resolvePreparedTxn polling path in
+// kvs/server/main.go.
 func (txn *Txn) Commit() error {
 	if txn.id == nil {
 		return errors.New("cannot commit a transaction that has not begun")
 	}
+	activeTxns.Delete(*txn.id) // see deadlock.go; a finishing txn has nothing left to wait on
+	if len(txn.participants) == 0 {
+		return nil // read-only or no-op transaction touched no shard
+	}
+	if len(txn.writeSet) == 0 {
+		// Auto-detected read-only transaction: every participant only has
+		// read locks to drop, so a single Abort round (which just releases
+		// locks) is enough - no need to pay for a full Prepare+Commit 2PC
+		// when there's nothing to make durable.
+		return txn.Abort()
+	}
 
-	lead := true // Make first request the lead for server-side logging
-	for server := range txn.usedServers.values {
-		request := kvs.CommitRequest{
-			Txid: *txn.id,
-			Lead: lead,
-		}
-		lead = false
-		response := kvs.CommitResponse{} // empty struct
-		err := server.rpcClient.Call("KVService.Commit", &request, &response)
+	primary := txn.electPrimary()
+
+	for _, server := range txn.participants {
+		vote, err := server.Prepare(*txn.id, primary.addr)
 		if err != nil {
-			log.Printf("Error during Commit: %v", err)
+			log.Printf("Error during Prepare: %v", err)
+		}
+		if err != nil || !vote {
+			// A participant refused (or is unreachable) - most commonly
+			// because an earlier Get/Put on it already lost a lock conflict
+			// and the server cleaned up that txn's state itself. Nothing
+			// durable has been decided yet (no COMMIT record has been
+			// logged), so it's safe to just abort whatever's left rather
+			// than fail the whole Commit call; the original single-phase
+			// Commit had this same "errors are only recorded in the logs"
+			// contract.
+			_ = txn.Abort()
+			return nil
+		}
+	}
+
+	txn.logDecision("COMMIT", primary.addr)
+
+	txn.sendDecision(primary, true)
+	for _, server := range txn.participants {
+		if server != primary {
+			txn.sendDecision(server, false)
 		}
 	}
 	return nil // The actual error is only recorded in the logs.
 }
 
+// logDecision durably records this transaction's outcome in coordinatorLog,
+// if one is open (see kvs/client's main() and -coordinator-log), before any
+// phase-two RPC goes out. A nil coordinatorLog (only in the test harness,
+// which never runs main()) just skips logging - the original
+// in-memory-only behavior - rather than failing the commit.
+func (txn *Txn) logDecision(decision string, primaryAddr string) {
+	if coordinatorLog == nil {
+		return
+	}
+	addrs := make([]string, len(txn.participants))
+	for i, server := range txn.participants {
+		addrs[i] = server.addr
+	}
+	record := kvs.CoordinatorRecord{
+		Txid:         *txn.id,
+		Participants: addrs,
+		PrimaryAddr:  primaryAddr,
+		Decision:     decision,
+	}
+	if err := coordinatorLog.Append(record); err != nil {
+		log.Printf("Error appending coordinator log record for txn %d: %v", *txn.id, err)
+	}
+}
+
+// sendDecision issues the phase-two Commit RPC to one participant. lead
+// matches the original CommitRequest.Lead semantics: only the primary's
+// commit counts toward the server's commit-rate stat.
+func (txn *Txn) sendDecision(server *Client, lead bool) {
+	request := kvs.CommitRequest{
+		Txid:     *txn.id,
+		Lead:     lead,
+		ClientId: server.clientId,
+		SeqNum:   server.nextSeqNum(),
+	}
+	response := kvs.CommitResponse{}
+	if err := server.call("KVService.Commit", &request, &response); err != nil {
+		log.Printf("Error during Commit: %v", err)
+	}
+}
+
 func (txn *Txn) Abort() error {
 	if txn.id == nil {
 		return errors.New("cannot abort a transaction that has not begun")
 	}
+	activeTxns.Delete(*txn.id) // see deadlock.go; a finishing txn has nothing left to wait on
 
 	for server := range txn.usedServers.values {
 		request := kvs.AbortRequest{
-			Txid: *txn.id,
+			Txid:     *txn.id,
+			ClientId: server.clientId,
+			SeqNum:   server.nextSeqNum(),
 		}
 		response := kvs.AbortResponse{}
-		err := server.rpcClient.Call("KVService.Abort", &request, &response)
+		err := server.call("KVService.Abort", &request, &response)
 		if err != nil {
 			log.Printf("Error during Abort: %v", err)
 			return err
@@ -119,6 +351,9 @@ func (txn *Txn) Abort() error {
 
 func (txn *Txn) getServer(key string) *Client {
 	server := serverFromKey(&key, txn.allServers)
+	if !txn.usedServers.Contains(server) {
+		txn.participants = append(txn.participants, server)
+	}
 	txn.usedServers.Add(server)
 	return server
 }
@@ -133,16 +368,25 @@ func (txn *Txn) Get(key string) (string, error) {
 		return cachedVal, nil
 	}
 
+	if txn.readOnly {
+		// Fast path: no locks to take, nothing to register for Commit/Abort
+		// to clean up, so we don't even need to route this through
+		// getServer's participant bookkeeping.
+		server := serverFromKey(&key, txn.allServers)
+		values, err := server.ReadOnlyTxn([]string{key})
+		if err != nil {
+			return "", fmt.Errorf("server-side error raised: %w", err)
+		}
+		return values[0], nil
+	}
+
 	resp, err := txn.getServer(key).Get(key, *txn.id) // txn.getServer(key) -> *Client, then call *Client.Get()
 	if err != nil {
-		// Check if this is a lock conflict (retryable) or real error (fatal)
-		if strings.Contains(err.Error(), "Cannot acquire") || strings.Contains(err.Error(), "Abort:") {
-			// Lock conflict - let caller handle retry
-			return "", fmt.Errorf("lock conflict: %w", err)
+		classified := kvs.ClassifyError(err)
+		if errors.Is(classified, kvs.ErrFatal) {
+			_ = txn.Abort()
 		}
-		// Real error - abort transaction
-		_ = txn.Abort()
-		return "", fmt.Errorf("server-side error raised: %w", err)
+		return "", classified
 	}
 
 	return resp, nil
@@ -154,14 +398,11 @@ func (txn *Txn) Put(key string, value string) error {
 	}
 	err := txn.getServer(key).Put(key, value, *txn.id)
 	if err != nil {
-		// Check if this is a lock conflict (retryable) or real error (fatal)
-		if strings.Contains(err.Error(), "Cannot acquire") || strings.Contains(err.Error(), "Abort:") {
-			// Lock conflict - let caller handle retry
-			return fmt.Errorf("lock conflict: %w", err)
+		classified := kvs.ClassifyError(err)
+		if errors.Is(classified, kvs.ErrFatal) {
+			_ = txn.Abort()
 		}
-		// Real error - abort transaction
-		_ = txn.Abort()
-		return fmt.Errorf("server-side error raised: %w", err)
+		return classified
 	}
 	txn.writeSet[key] = value // update "cache"
 	return nil
@@ -266,14 +507,16 @@ func performTransfer(clientId int, servers []*Client) error {
 		// Read all account balances in order
 		balances := make(map[int]int)
 		readSuccess := true
+		deadlockVictim := false
 
 		for _, account := range accounts {
 			key := fmt.Sprintf("account_%d", account)
 			balStr, err := txn.Get(key)
 			if err != nil {
 				txn.Abort()
-				if strings.Contains(err.Error(), "lock conflict") {
+				if errors.Is(err, kvs.ErrLockConflict) || errors.Is(err, kvs.ErrDeadlockVictim) {
 					readSuccess = false
+					deadlockVictim = errors.Is(err, kvs.ErrDeadlockVictim)
 					break
 				}
 				return fmt.Errorf("error reading account %d: %w", account, err)
@@ -291,8 +534,9 @@ func performTransfer(clientId int, servers []*Client) error {
 		}
 
 		if !readSuccess {
-			// Apply exponential backoff for lock conflicts
-			if retry < maxRetries-1 {
+			// A deadlock victim has nothing left to wait out - restart right
+			// away. An ordinary lock conflict still gets the normal backoff.
+			if !deadlockVictim && retry < maxRetries-1 {
 				backoffTime := time.Duration(baseDelay*(1<<uint(retry))) * time.Millisecond
 				if backoffTime > 2*time.Second {
 					backoffTime = 2 * time.Second // Cap at 2 seconds
@@ -324,8 +568,9 @@ func performTransfer(clientId int, servers []*Client) error {
 			err := txn.Put(key, fmt.Sprintf("%d", newBalance))
 			if err != nil {
 				txn.Abort()
-				if strings.Contains(err.Error(), "lock conflict") {
+				if errors.Is(err, kvs.ErrLockConflict) || errors.Is(err, kvs.ErrDeadlockVictim) {
 					updateSuccess = false
+					deadlockVictim = errors.Is(err, kvs.ErrDeadlockVictim)
 					break
 				}
 				return fmt.Errorf("error updating account %d: %w", account, err)
@@ -333,8 +578,9 @@ func performTransfer(clientId int, servers []*Client) error {
 		}
 
 		if !updateSuccess {
-			// Apply exponential backoff for lock conflicts
-			if retry < maxRetries-1 {
+			// A deadlock victim has nothing left to wait out - restart right
+			// away. An ordinary lock conflict still gets the normal backoff.
+			if !deadlockVictim && retry < maxRetries-1 {
 				backoffTime := time.Duration(baseDelay*(1<<uint(retry))) * time.Millisecond
 				if backoffTime > 2*time.Second {
 					backoffTime = 2 * time.Second // Cap at 2 seconds
@@ -376,14 +622,16 @@ func checkTotalBalance(servers []*Client) error {
 		total := 0
 		balances := make([]int, 10)
 		readSuccess := true
+		deadlockVictim := false
 
 		for i := 0; i < 10; i++ {
 			key := fmt.Sprintf("account_%d", i)
 			balStr, err := txn.Get(key)
 			if err != nil {
 				txn.Abort()
-				if strings.Contains(err.Error(), "lock conflict") {
+				if errors.Is(err, kvs.ErrLockConflict) || errors.Is(err, kvs.ErrDeadlockVictim) {
 					readSuccess = false
+					deadlockVictim = errors.Is(err, kvs.ErrDeadlockVictim)
 					break
 				}
 				log.Printf("Error getting balance for account %d: %v", i, err)
@@ -405,10 +653,13 @@ func checkTotalBalance(servers []*Client) error {
 		}
 
 		if !readSuccess {
-			// Apply exponential backoff
-			backoffTime := time.Duration(50*(1<<uint(retry))) * time.Millisecond
-			jitter := time.Duration(randGen.Intn(int(backoffTime / 2)))
-			time.Sleep(backoffTime + jitter)
+			// A deadlock victim has nothing left to wait out - restart right
+			// away. An ordinary lock conflict still gets the normal backoff.
+			if !deadlockVictim {
+				backoffTime := time.Duration(50*(1<<uint(retry))) * time.Millisecond
+				jitter := time.Duration(randGen.Intn(int(backoffTime / 2)))
+				time.Sleep(backoffTime + jitter)
+			}
 			continue
 		}
 
@@ -526,12 +777,20 @@ func main() {
 	theta := flag.Float64("theta", 0.99, "Zipfian distribution skew parameter")
 	workload := flag.String("workload", "YCSB-B", "Workload type (YCSB-A, YCSB-B, YCSB-C)")
 	secs := flag.Int("secs", 30, "Duration in seconds for each client to run")
+	coordinatorLogPath := flag.String("coordinator-log", "coordinator.log", "Path to this coordinator's 2PC decision log")
 	flag.Parse()
 
 	if len(hosts) == 0 {
 		hosts = append(hosts, "localhost:8080")
 	}
 
+	var err error
+	coordinatorLog, err = kvs.NewCoordinatorLog(*coordinatorLogPath)
+	if err != nil {
+		log.Fatalf("failed to open coordinator log %s: %v", *coordinatorLogPath, err)
+	}
+	recoverCoordinatorLog(coordinatorLog)
+
 	fmt.Printf(
 		"hosts %v\n"+
 			"theta %.2f\n"+
@@ -546,6 +805,7 @@ func main() {
 	resultsCh := make(chan uint64)
 
 	connections := dialHosts(hosts)
+	startDeadlockDetector(&done)
 
 	if *workload == "xfer" {
 		// Run transfer workload with multiple clients
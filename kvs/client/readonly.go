@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rstutsman/cs6450-labs/kvs"
+)
+
+// ReadOnlyTxn sends a single-round batch read to one shard, bypassing the
+// per-key locking a read/write Get does.
+func (client *Client) ReadOnlyTxn(keys []string) ([]string, error) {
+	request := kvs.ReadOnlyTxnRequest{Keys: keys}
+	response := kvs.ReadOnlyTxnResponse{}
+	if err := client.call("KVService.ReadOnlyTxn", &request, &response); err != nil {
+		return nil, err
+	}
+	return response.Values, nil
+}
+
+// ReadOnly marks txn as read-only: Get and BatchGet route through the
+// lock-free ReadOnlyTxn RPC instead of the normal Get/Put path, so
+// concurrent writers never abort this transaction and it never needs a
+// Commit/Abort call to release anything. Calling Put on a ReadOnly txn is a
+// programmer error - it still takes the normal write-lock path and will
+// leave server-side lock state that nothing cleans up, since the caller is
+// expected to skip Commit entirely for a read-only transaction.
+func (txn *Txn) ReadOnly() *Txn {
+	txn.readOnly = true
+	return txn
+}
+
+// BatchGet reads every key in one round trip per shard - each shard
+// involved in keys gets a single RPC carrying just the keys it owns, run
+// concurrently, instead of one RPC per key. A read-only txn (see ReadOnly)
+// uses the lock-free ReadOnlyTxn RPC, same as Get's fast path; otherwise
+// each shard gets a locked KVService.BatchGet, registering as a 2PC
+// participant through getServer exactly like individual Gets would.
+func (txn *Txn) BatchGet(keys []string) (map[string]string, error) {
+	if txn.id == nil {
+		return nil, errors.New("cannot call BatchGet on a transaction that has not begun")
+	}
+
+	byServer := make(map[*Client][]string)
+	for _, key := range keys {
+		var server *Client
+		if txn.readOnly {
+			server = serverFromKey(&key, txn.allServers)
+		} else {
+			server = txn.getServer(key)
+		}
+		byServer[server] = append(byServer[server], key)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	result := make(map[string]string, len(keys))
+	var firstErr error
+
+	for server, serverKeys := range byServer {
+		wg.Add(1)
+		go func(server *Client, serverKeys []string) {
+			defer wg.Done()
+			var values []string
+			var err error
+			if txn.readOnly {
+				values, err = server.ReadOnlyTxn(serverKeys)
+			} else {
+				values, err = server.BatchGet(serverKeys, *txn.id)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for i, key := range serverKeys {
+				result[key] = values[i]
+			}
+		}(server, serverKeys)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		if !txn.readOnly {
+			classified := kvs.ClassifyError(firstErr)
+			if errors.Is(classified, kvs.ErrFatal) {
+				_ = txn.Abort()
+			}
+			return nil, classified
+		}
+		return nil, firstErr
+	}
+	return result, nil
+}
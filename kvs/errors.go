@@ -0,0 +1,44 @@
+package kvs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrLockConflict, ErrDeadlockVictim, and ErrFatal classify what a failed
+// Get/Put means for the caller's retry loop (see kvs/client's Txn.Get/Put
+// and performTransfer). ErrLockConflict means retry after the normal
+// backoff, once whatever held the lock has moved on. ErrDeadlockVictim
+// means this transaction was specifically chosen to break a cross-shard
+// wait cycle (see kvs/server/lockmanager.go's killAsDeadlockVictim and
+// kvs/client/deadlock.go) and should restart right away, without backoff,
+// since there's nothing left to wait out. ErrFatal means anything else - a
+// real error the transaction should abort on instead of retrying.
+var (
+	ErrLockConflict   = errors.New("lock conflict")
+	ErrDeadlockVictim = errors.New("deadlock victim")
+	ErrFatal          = errors.New("fatal error")
+)
+
+// ClassifyError wraps a raw server error as one of ErrLockConflict,
+// ErrDeadlockVictim, or ErrFatal, so callers can branch with errors.Is
+// instead of re-deriving the classification from the message text
+// themselves at every call site. net/rpc only carries errors back to the
+// client as plain strings, so the server still has to encode which case
+// applies in a recognizable prefix (see kvs/server/main.go's Get/Put) -
+// this is just the one place that prefix gets interpreted.
+func ClassifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "Deadlock:"):
+		return fmt.Errorf("%w: %s", ErrDeadlockVictim, msg)
+	case strings.HasPrefix(msg, "Cannot acquire"), strings.HasPrefix(msg, "Abort:"):
+		return fmt.Errorf("%w: %s", ErrLockConflict, msg)
+	default:
+		return fmt.Errorf("%w: %s", ErrFatal, msg)
+	}
+}
@@ -4,6 +4,17 @@ type PutRequest struct {
 	Key   string
 	Value string
 	Txid  uint64
+	// StartTs is the MVCC transaction identifier to prewrite under (see
+	// BeginRequest). Unused, and left zero, when the server is running 2PL,
+	// where Txid alone identifies the transaction.
+	StartTs uint64
+	// ClientId/SeqNum identify this RPC for duplicate detection when the
+	// shard is running in replicated (-replicas) mode: a retry after
+	// ErrWrongLeader carries the same pair, so the new leader can recognize
+	// an op it (or a prior leader) already applied instead of applying it
+	// twice. Unused, and left zero, outside replicated mode.
+	ClientId uint64
+	SeqNum   uint64
 }
 
 type PutResponse struct {
@@ -12,23 +23,245 @@ type PutResponse struct {
 type GetRequest struct {
 	Key  string
 	Txid uint64
+	// StartTs is the MVCC snapshot timestamp to read at (see BeginRequest).
+	// Unused, and left zero, when the server is running 2PL.
+	StartTs uint64
+	// ClientId/SeqNum: see PutRequest.
+	ClientId uint64
+	SeqNum   uint64
 }
 
 type GetResponse struct {
 	Value string
+	// Version is bumped on every commit that writes Key, so a client can
+	// detect whether the value it read is still current (see CmpVersion).
+	Version uint64
 }
 
 type CommitRequest struct {
 	Txid uint64
 	Lead bool
+	// StartTs/CommitTs drive MVCC commit (Prewrite -> Commit); unused in 2PL
+	// mode, where Commit instead applies the buffered ops recorded by Txid.
+	StartTs  uint64
+	CommitTs uint64
+	// ClientId/SeqNum: see PutRequest.
+	ClientId uint64
+	SeqNum   uint64
+}
+
+// PrepareRequest is phase one of cross-shard 2PC: the participant durably
+// records its buffered ops for Txid and votes yes/no. PrimaryAddr names the
+// shard elected (by hash of Txid) to hold the transaction's final decision,
+// so this participant knows whom to ask if the coordinator never follows up
+// with a Commit/Abort (see kvs/server/wal.go).
+type PrepareRequest struct {
+	Txid        uint64
+	PrimaryAddr string
+}
+
+type PrepareResponse struct {
+	Vote bool
+}
+
+// StatusRequest asks a shard (normally the primary) for the final decision
+// on Txid, so a participant that lost contact with the coordinator can
+// complete the termination protocol on its own.
+type StatusRequest struct {
+	Txid uint64
+}
+
+type StatusResponse struct {
+	Decision string // "", "COMMIT", or "ABORT"; "" means still undecided
+}
+
+// ReadOnlyTxnRequest asks for a batch of committed values without any of
+// the bookkeeping a read/write transaction needs: no per-txn lock state, no
+// entry in the transactions log, and no Commit/Abort call to follow up
+// with. See Txn.ReadOnly / Txn.BatchGet on the client.
+type ReadOnlyTxnRequest struct {
+	Keys []string
+}
+
+type ReadOnlyTxnResponse struct {
+	Values []string // Values[i] is the current value of Keys[i], "" if absent
 }
 
 type CommitResponse struct {
+	// Versions maps each key this transaction wrote to the version it now
+	// holds post-commit (see VersionedValue), so a caller can observe write
+	// order without a racy follow-up Get - see conformance/, which builds
+	// a conflict graph from exactly this. Left nil outside 2PL mode (MVCC
+	// and Raft-replicated commits don't thread a result back through
+	// proposeRaft today).
+	Versions map[string]uint64
 }
 
 type AbortRequest struct {
 	Txid uint64
+	// StartTs identifies the MVCC transaction to roll back; unused in 2PL
+	// mode.
+	StartTs uint64
+	// ClientId/SeqNum: see PutRequest.
+	ClientId uint64
+	SeqNum   uint64
 }
 
 type AbortResponse struct {
 }
+
+// BeginRequest asks the server's timestamp oracle for a snapshot start_ts.
+// Only meaningful when the server is running in MVCC mode (-cc=mvcc); 2PL
+// mode ignores timestamps entirely and ties everything to Txid instead.
+type BeginRequest struct {
+}
+
+type BeginResponse struct {
+	StartTs uint64
+}
+
+// PrewriteRequest stages a buffered write under a lock in MVCC mode, the
+// first phase of the commit protocol described in kvs/server/mvcc.go.
+// Primary identifies the key whose lock decides the fate of the whole
+// transaction, matching the TiKV percolator-style scheme.
+type PrewriteRequest struct {
+	Key     string
+	Value   string
+	StartTs uint64
+	Primary string
+}
+
+type PrewriteResponse struct {
+}
+
+// CompareTarget selects which facet of a key a Compare predicate inspects.
+type CompareTarget int
+
+const (
+	CompareValue CompareTarget = iota
+	CompareVersion
+	CompareExists
+)
+
+// CompareOp is the relational operator applied between the target and the
+// predicate's expected value.
+type CompareOp int
+
+const (
+	CmpEqual CompareOp = iota
+	CmpNotEqual
+	CmpGreater
+	CmpLess
+)
+
+// Compare is one predicate of an etcd-style conditional transaction: "does
+// Key's Target compare Op against the expected Value/Version/Exists?". See
+// ConditionalCommitRequest.
+type Compare struct {
+	Key     string
+	Target  CompareTarget
+	Op      CompareOp
+	Value   string // compared against when Target == CompareValue
+	Version uint64 // compared against when Target == CompareVersion
+	Exists  bool   // compared against when Target == CompareExists
+}
+
+// ConditionalOp is one write to apply when a conditional transaction's Then
+// or Else branch is chosen. Only PUT is supported today; a CAS-delete would
+// add a "DELETE" OpType here once the store supports tombstones.
+type ConditionalOp struct {
+	OpType string // "PUT"
+	Key    string
+	Value  string
+}
+
+// ConditionalCommitRequest evaluates Cmps under freshly-acquired locks and
+// atomically applies Then (if every Cmp holds) or Else (otherwise), all in
+// one RPC - the server-side half of Txn.If(...).Then(...).Else(...).Commit().
+type ConditionalCommitRequest struct {
+	Txid uint64
+	Cmps []Compare
+	Then []ConditionalOp
+	Else []ConditionalOp
+}
+
+type ConditionalCommitResponse struct {
+	Succeeded bool // true if every Cmp held and Then was applied, false if Else was applied
+	// Values holds, for every key referenced by Cmps, the value observed
+	// while evaluating the predicates - saves the caller a round trip to
+	// find out why a CAS failed.
+	Values map[string]string
+}
+
+// BatchGetRequest reads multiple keys from one shard in a single RPC, each
+// acquiring a read lock recorded in the transaction's op log just like an
+// individual Get - the locked-path counterpart to ReadOnlyTxnRequest, which
+// skips locking (and the whole Commit/Abort lifecycle) entirely.
+type BatchGetRequest struct {
+	Keys []string
+	Txid uint64
+}
+
+type BatchGetResponse struct {
+	Values []string // Values[i] is the current value of Keys[i], "" if absent
+}
+
+// BatchPutRequest buffers multiple writes on one shard in a single RPC, each
+// acquiring a write lock recorded in the transaction's op log. Like a plain
+// Put, nothing is applied to the store until Commit.
+type BatchPutRequest struct {
+	Keys   []string
+	Values []string
+	Txid   uint64
+}
+
+type BatchPutResponse struct {
+}
+
+// ScanRequest asks one shard for up to Limit keys in [StartKey, EndKey),
+// each acquiring a read lock recorded in the transaction's op log just like
+// an individual Get. Limit <= 0 means unbounded.
+type ScanRequest struct {
+	StartKey string
+	EndKey   string
+	Limit    int
+	Txid     uint64
+}
+
+type ScanResponse struct {
+	Keys   []string
+	Values []string
+	// Versions[i] is the version of Keys[i] at scan time, mirroring
+	// GetResponse.Version - conformance/ uses it to feed a scan's
+	// observations into its conflict graph the same way a "get" does.
+	Versions []uint64
+}
+
+// WaitingOnRequest asks one shard for a transaction's local waits-for
+// edges: which other txids, if any, request.Txid is currently queued
+// behind on this shard. kvs/client's deadlock detector polls this across
+// every shard a transaction has touched to assemble a global waits-for
+// graph, since each shard's wound-wait (see ageOf in kvs/server/
+// lockmanager.go) only orders ages locally - a cycle that never appears on
+// any single shard can still form across shards.
+type WaitingOnRequest struct {
+	Txid uint64
+}
+
+type WaitingOnResponse struct {
+	WaitingOn []uint64
+}
+
+// KillDeadlockVictimRequest force-aborts Txid on one shard: sent by
+// kvs/client's deadlock detector once it finds a waits-for cycle, to the
+// lowest-Txid member of that cycle (chosen by Txid alone, not by the
+// first-seen ages ageOf/wound use locally). The victim's next Get/Put reports
+// ErrDeadlockVictim instead of the ordinary lock-conflict error, so it
+// retries right away instead of waiting out a backoff for a wait that will
+// never resolve on its own.
+type KillDeadlockVictimRequest struct {
+	Txid uint64
+}
+
+type KillDeadlockVictimResponse struct {
+}
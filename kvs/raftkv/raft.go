@@ -0,0 +1,503 @@
+// Package raftkv implements single-group leader election and log
+// replication over net/rpc, modeled on the MIT 6.824 raft lab: a Raft group
+// backs one shard, and kvs/server wires a KVService's Get/Put/Commit/Abort
+// through it (see kvs/server/raftkv.go) so a shard survives the crash of
+// any minority of its replicas instead of losing the transaction outright.
+package raftkv
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+const (
+	heartbeatInterval   = 50 * time.Millisecond
+	electionTimeoutMin  = 300 * time.Millisecond
+	electionTimeoutSpan = 300 * time.Millisecond
+)
+
+// LogEntry is one slot in the replicated log. Command is opaque to Raft -
+// kvs/server defines what it actually holds (see raftCommand).
+type LogEntry struct {
+	Term    int
+	Command interface{}
+}
+
+// ApplyMsg is delivered on every replica - not just the leader - once the
+// entry at Index has been replicated to a majority and is safe to execute
+// against local state, in log order.
+type ApplyMsg struct {
+	Index   int
+	Command interface{}
+}
+
+type RequestVoteArgs struct {
+	Term         int
+	CandidateId  int
+	LastLogIndex int
+	LastLogTerm  int
+}
+
+type RequestVoteReply struct {
+	Term        int
+	VoteGranted bool
+}
+
+type AppendEntriesArgs struct {
+	Term         int
+	LeaderId     int
+	PrevLogIndex int
+	PrevLogTerm  int
+	Entries      []LogEntry
+	LeaderCommit int
+}
+
+type AppendEntriesReply struct {
+	Term    int
+	Success bool
+	// ConflictIndex lets a follower's rejection skip the leader straight to
+	// the first slot that could possibly match, instead of the leader
+	// backing off nextIndex one entry at a time.
+	ConflictIndex int
+}
+
+// ErrNotLeader is returned by Start when called on a non-leader replica.
+// kvs/server's RPC handlers translate this into the "ErrWrongLeader"
+// wire-level error the client's retry loop watches for (see
+// kvs/client/main.go's call helper).
+var ErrNotLeader = errors.New("raftkv: not the leader")
+
+// Raft is one replica of one shard's consensus group. Deliberately has no
+// persistence or snapshotting: this repo's shards are small and short-lived
+// enough (lab/test scale, not a long-running production cluster) that
+// losing in-memory Raft state if every replica in a group crashed at once
+// is an accepted gap, not a correctness goal here.
+type Raft struct {
+	mu    sync.Mutex
+	addrs []string // every group member, including this one, addrs[me]
+	me    int
+	conns []*rpc.Client // lazily dialed, parallel to addrs
+
+	currentTerm int
+	votedFor    int        // -1 means none yet this term
+	log         []LogEntry // log[0] is a dummy sentinel (Term 0) so PrevLogIndex 0 needs no special case
+
+	commitIndex int
+	lastApplied int
+	state       role
+	lastHeard   time.Time // last time we had a reason to believe the current leader (or term) is alive
+
+	nextIndex  []int
+	matchIndex []int
+
+	applyCh chan ApplyMsg
+	// applyMu serializes applyCommitted's read-then-send sequence across its
+	// concurrent callers (see applyCommitted).
+	applyMu sync.Mutex
+}
+
+// Make starts a Raft replica for the group described by addrs, with me as
+// this replica's index into addrs. Call rpc.Register(rf) on the same
+// net/rpc server that serves the shard's KVService so peers can reach
+// RequestVote/AppendEntries.
+func Make(addrs []string, me int, applyCh chan ApplyMsg) *Raft {
+	rf := &Raft{
+		addrs:      addrs,
+		me:         me,
+		conns:      make([]*rpc.Client, len(addrs)),
+		votedFor:   -1,
+		log:        []LogEntry{{Term: 0}},
+		state:      follower,
+		lastHeard:  time.Now(),
+		nextIndex:  make([]int, len(addrs)),
+		matchIndex: make([]int, len(addrs)),
+		applyCh:    applyCh,
+	}
+	go rf.electionTicker()
+	return rf
+}
+
+// GetState reports whether this replica currently believes itself to be the
+// group's leader, and its current term.
+func (rf *Raft) GetState() (term int, isLeader bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.currentTerm, rf.state == leader
+}
+
+// Start appends command to the log if this replica is the leader, returning
+// the index it will occupy once committed - the caller (see
+// kvs/server/raftkv.go) waits for that index to reach applyCh before
+// answering the client. Returns ErrNotLeader otherwise, so the caller can
+// tell its client to try the next replica.
+func (rf *Raft) Start(command interface{}) (index int, term int, err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.state != leader {
+		return 0, rf.currentTerm, ErrNotLeader
+	}
+
+	rf.log = append(rf.log, LogEntry{Term: rf.currentTerm, Command: command})
+	index = len(rf.log) - 1
+	rf.matchIndex[rf.me] = index
+	rf.nextIndex[rf.me] = index + 1
+
+	go rf.replicateToAll()
+	return index, rf.currentTerm, nil
+}
+
+func (rf *Raft) lastLogIndexAndTerm() (int, int) {
+	last := len(rf.log) - 1
+	return last, rf.log[last].Term
+}
+
+// RequestVote is the RPC handler a candidate calls on its peers.
+func (rf *Raft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if args.Term > rf.currentTerm {
+		rf.becomeFollowerLocked(args.Term)
+	}
+
+	reply.Term = rf.currentTerm
+	reply.VoteGranted = false
+	if args.Term < rf.currentTerm {
+		return nil
+	}
+
+	lastIndex, lastTerm := rf.lastLogIndexAndTerm()
+	logOk := args.LastLogTerm > lastTerm ||
+		(args.LastLogTerm == lastTerm && args.LastLogIndex >= lastIndex)
+
+	if (rf.votedFor == -1 || rf.votedFor == args.CandidateId) && logOk {
+		rf.votedFor = args.CandidateId
+		rf.lastHeard = time.Now()
+		reply.VoteGranted = true
+	}
+	return nil
+}
+
+// AppendEntries is the RPC handler a leader calls on its followers, both for
+// heartbeats (Entries empty) and replication.
+func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	reply.Term = rf.currentTerm
+	reply.Success = false
+
+	if args.Term < rf.currentTerm {
+		return nil
+	}
+	if args.Term > rf.currentTerm || rf.state != follower {
+		rf.becomeFollowerLocked(args.Term)
+	}
+	rf.lastHeard = time.Now()
+
+	if args.PrevLogIndex >= len(rf.log) {
+		reply.ConflictIndex = len(rf.log)
+		return nil
+	}
+	if rf.log[args.PrevLogIndex].Term != args.PrevLogTerm {
+		conflictTerm := rf.log[args.PrevLogIndex].Term
+		i := args.PrevLogIndex
+		for i > 0 && rf.log[i-1].Term == conflictTerm {
+			i--
+		}
+		reply.ConflictIndex = i
+		return nil
+	}
+
+	for i, entry := range args.Entries {
+		pos := args.PrevLogIndex + 1 + i
+		if pos < len(rf.log) {
+			if rf.log[pos].Term != entry.Term {
+				rf.log = rf.log[:pos]
+				rf.log = append(rf.log, entry)
+			}
+		} else {
+			rf.log = append(rf.log, entry)
+		}
+	}
+
+	if args.LeaderCommit > rf.commitIndex {
+		lastNew := args.PrevLogIndex + len(args.Entries)
+		if args.LeaderCommit < lastNew {
+			rf.commitIndex = args.LeaderCommit
+		} else {
+			rf.commitIndex = lastNew
+		}
+		go rf.applyCommitted()
+	}
+
+	reply.Success = true
+	return nil
+}
+
+func (rf *Raft) becomeFollowerLocked(term int) {
+	rf.state = follower
+	rf.currentTerm = term
+	rf.votedFor = -1
+}
+
+// electionTicker wakes periodically and starts an election if this replica
+// hasn't heard from a leader (or granted a vote) within a randomized
+// timeout - randomized so a single split vote doesn't repeat forever.
+func (rf *Raft) electionTicker() {
+	for {
+		timeout := electionTimeoutMin + time.Duration(rand.Int63n(int64(electionTimeoutSpan)))
+		time.Sleep(timeout)
+
+		rf.mu.Lock()
+		elapsed := time.Since(rf.lastHeard)
+		isLeader := rf.state == leader
+		rf.mu.Unlock()
+
+		if !isLeader && elapsed >= timeout {
+			rf.startElection()
+		}
+	}
+}
+
+func (rf *Raft) startElection() {
+	rf.mu.Lock()
+	rf.state = candidate
+	rf.currentTerm++
+	rf.votedFor = rf.me
+	rf.lastHeard = time.Now()
+	term := rf.currentTerm
+	lastIndex, lastTerm := rf.lastLogIndexAndTerm()
+	rf.mu.Unlock()
+
+	args := &RequestVoteArgs{Term: term, CandidateId: rf.me, LastLogIndex: lastIndex, LastLogTerm: lastTerm}
+
+	votes := 1 // voted for self
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := range rf.addrs {
+		if i == rf.me {
+			continue
+		}
+		wg.Add(1)
+		go func(peer int) {
+			defer wg.Done()
+			reply := RequestVoteReply{}
+			if err := rf.callPeer(peer, "Raft.RequestVote", args, &reply); err != nil {
+				return
+			}
+
+			rf.mu.Lock()
+			defer rf.mu.Unlock()
+			if reply.Term > rf.currentTerm {
+				rf.becomeFollowerLocked(reply.Term)
+				return
+			}
+			if reply.VoteGranted && rf.state == candidate && rf.currentTerm == term {
+				mu.Lock()
+				votes++
+				won := votes*2 > len(rf.addrs)
+				mu.Unlock()
+				if won {
+					rf.becomeLeaderLocked()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// becomeLeaderLocked must be called with rf.mu held.
+func (rf *Raft) becomeLeaderLocked() {
+	if rf.state != candidate {
+		return
+	}
+	rf.state = leader
+	for i := range rf.addrs {
+		rf.nextIndex[i] = len(rf.log)
+		rf.matchIndex[i] = 0
+	}
+	go rf.leaderLoop(rf.currentTerm)
+}
+
+// leaderLoop sends periodic heartbeats/replication until this replica steps
+// down from term (a higher term observed, or loses leadership another way).
+func (rf *Raft) leaderLoop(term int) {
+	for {
+		rf.mu.Lock()
+		stillLeader := rf.state == leader && rf.currentTerm == term
+		rf.mu.Unlock()
+		if !stillLeader {
+			return
+		}
+
+		rf.replicateToAll()
+		time.Sleep(heartbeatInterval)
+	}
+}
+
+func (rf *Raft) replicateToAll() {
+	rf.mu.Lock()
+	if rf.state != leader {
+		rf.mu.Unlock()
+		return
+	}
+	term := rf.currentTerm
+	rf.mu.Unlock()
+
+	for i := range rf.addrs {
+		if i == rf.me {
+			continue
+		}
+		go rf.replicateTo(i, term)
+	}
+}
+
+func (rf *Raft) replicateTo(peer int, term int) {
+	rf.mu.Lock()
+	if rf.state != leader || rf.currentTerm != term {
+		rf.mu.Unlock()
+		return
+	}
+	prevIndex := rf.nextIndex[peer] - 1
+	if prevIndex < 0 {
+		prevIndex = 0
+	}
+	prevTerm := rf.log[prevIndex].Term
+	entries := make([]LogEntry, len(rf.log[prevIndex+1:]))
+	copy(entries, rf.log[prevIndex+1:])
+	args := &AppendEntriesArgs{
+		Term:         term,
+		LeaderId:     rf.me,
+		PrevLogIndex: prevIndex,
+		PrevLogTerm:  prevTerm,
+		Entries:      entries,
+		LeaderCommit: rf.commitIndex,
+	}
+	rf.mu.Unlock()
+
+	reply := AppendEntriesReply{}
+	if err := rf.callPeer(peer, "Raft.AppendEntries", args, &reply); err != nil {
+		return
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if reply.Term > rf.currentTerm {
+		rf.becomeFollowerLocked(reply.Term)
+		return
+	}
+	if rf.state != leader || rf.currentTerm != term {
+		return
+	}
+
+	if reply.Success {
+		rf.matchIndex[peer] = prevIndex + len(entries)
+		rf.nextIndex[peer] = rf.matchIndex[peer] + 1
+		rf.advanceCommitIndexLocked()
+		return
+	}
+
+	if reply.ConflictIndex > 0 {
+		rf.nextIndex[peer] = reply.ConflictIndex
+	} else if rf.nextIndex[peer] > 1 {
+		rf.nextIndex[peer]--
+	}
+}
+
+// advanceCommitIndexLocked applies the Raft safety rule (Figure 8): commit a
+// majority-replicated index only once it's known to hold an entry from the
+// leader's own current term, so an old-term entry a future leader might
+// still overwrite is never prematurely committed. Must be called with
+// rf.mu held.
+func (rf *Raft) advanceCommitIndexLocked() {
+	for n := len(rf.log) - 1; n > rf.commitIndex; n-- {
+		if rf.log[n].Term != rf.currentTerm {
+			continue
+		}
+		replicated := 0
+		for _, m := range rf.matchIndex {
+			if m >= n {
+				replicated++
+			}
+		}
+		if replicated*2 > len(rf.addrs) {
+			rf.commitIndex = n
+			go rf.applyCommitted()
+			return
+		}
+	}
+}
+
+// applyCommitted delivers every newly-committed entry to applyCh in order.
+// advanceCommitIndexLocked spawns a new goroutine running this on every
+// commit-index advance, called concurrently from each peer's replicateTo
+// reply handler - so two overlapping calls could otherwise compute
+// overlapping toApply slices and interleave their sends to applyCh out of
+// log order, violating the in-order-apply guarantee raftApplyLoop depends
+// on. applyMu holds the whole read-then-send sequence as one critical
+// section so only one call is ever delivering at a time.
+func (rf *Raft) applyCommitted() {
+	rf.applyMu.Lock()
+	defer rf.applyMu.Unlock()
+
+	rf.mu.Lock()
+	var toApply []ApplyMsg
+	for rf.lastApplied < rf.commitIndex {
+		rf.lastApplied++
+		toApply = append(toApply, ApplyMsg{Index: rf.lastApplied, Command: rf.log[rf.lastApplied].Command})
+	}
+	rf.mu.Unlock()
+
+	for _, msg := range toApply {
+		rf.applyCh <- msg
+	}
+}
+
+// callPeer lazily dials peer (reusing the connection afterward) and issues
+// one RPC, clearing the cached connection on failure so the next call
+// redials - a peer that's down or restarted shouldn't wedge this replica
+// forever.
+func (rf *Raft) callPeer(peer int, method string, args interface{}, reply interface{}) error {
+	rf.mu.Lock()
+	conn := rf.conns[peer]
+	addr := rf.addrs[peer]
+	rf.mu.Unlock()
+
+	if conn == nil {
+		var err error
+		conn, err = rpc.DialHTTP("tcp", addr)
+		if err != nil {
+			return err
+		}
+		rf.mu.Lock()
+		rf.conns[peer] = conn
+		rf.mu.Unlock()
+	}
+
+	err := conn.Call(method, args, reply)
+	if err != nil {
+		rf.mu.Lock()
+		if rf.conns[peer] == conn {
+			rf.conns[peer] = nil
+		}
+		rf.mu.Unlock()
+		log.Printf("raftkv: %s to %s failed: %v", method, addr, err)
+	}
+	return err
+}
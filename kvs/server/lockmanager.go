@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLockTimeout bounds how long a 2PL Get/Put waits in a lock's FIFO
+// queue before giving up, in case the holder ahead of it never commits or
+// aborts (a crashed client, say). See KVService.lockTimeout / -lock-timeout.
+const defaultLockTimeout = 2 * time.Second
+
+// waiter is one blocked lock request queued on a LockInfo. done receives at
+// most one value: nil once the lock is granted, or a "Deadlock:" error if
+// killAsDeadlockVictim pulls the request out of the queue. A wait that
+// times out is removed from the queue by acquireLock and gets nothing on
+// done.
+type waiter struct {
+	txid uint64
+	mode string // "read" or "write"
+	done chan error
+}
+
+// tracks lock holders for a specific "key"
+type LockInfo struct {
+	mu          sync.Mutex
+	readHolders map[uint64]bool // Set of transactions holding read locks
+	writeHolder *uint64         // Transaction holding write lock (nil if none)
+	waiters     []*waiter       // FIFO queue of requests blocked on this key
+}
+
+func NewLockInfo() *LockInfo {
+	return &LockInfo{
+		readHolders: make(map[uint64]bool),
+		writeHolder: nil,
+	}
+}
+
+// conflictingHolders returns the txids currently holding key in a way that
+// blocks txid's request for mode. Must be called with lockInfo.mu held.
+func conflictingHolders(lockInfo *LockInfo, txid uint64, mode string) []uint64 {
+	if mode == "read" {
+		if lockInfo.writeHolder != nil && *lockInfo.writeHolder != txid {
+			return []uint64{*lockInfo.writeHolder}
+		}
+		return nil
+	}
+
+	if lockInfo.writeHolder != nil {
+		if *lockInfo.writeHolder == txid {
+			return nil
+		}
+		return []uint64{*lockInfo.writeHolder}
+	}
+	var holders []uint64
+	for holder := range lockInfo.readHolders {
+		if holder != txid {
+			holders = append(holders, holder)
+		}
+	}
+	return holders
+}
+
+// grantLock records txid as holding mode on lockInfo. Must be called with
+// lockInfo.mu held.
+func grantLock(lockInfo *LockInfo, txid uint64, mode string) {
+	if mode == "read" {
+		lockInfo.readHolders[txid] = true
+		return
+	}
+	delete(lockInfo.readHolders, txid)
+	holder := txid
+	lockInfo.writeHolder = &holder
+}
+
+// wakeWaiters grants the front of the FIFO queue whatever mode is
+// compatible with the lock's current state, stopping at the first waiter it
+// can't satisfy yet - so a run of queued reads is woken together, but a
+// queued write stays blocked behind them (and blocks everyone behind it, in
+// turn), preserving FIFO order among queued requests. A new request that
+// finds no conflicting holder is granted by acquireLock without looking at
+// this queue, so a later read can still get ahead of a queued write. Must
+// be called with lockInfo.mu held.
+func wakeWaiters(lockInfo *LockInfo) {
+	for len(lockInfo.waiters) > 0 {
+		next := lockInfo.waiters[0]
+		if next.mode == "write" {
+			if lockInfo.writeHolder != nil || len(lockInfo.readHolders) > 0 {
+				return
+			}
+		} else if lockInfo.writeHolder != nil {
+			return
+		}
+		grantLock(lockInfo, next.txid, next.mode)
+		lockInfo.waiters = lockInfo.waiters[1:]
+		next.done <- nil
+	}
+}
+
+// ageOf returns txid's wound-wait priority: a counter assigned the first
+// time any lock request sees txid, monotonically increasing across the
+// service's lifetime. It stands in for a real start_ts, since 2PL mode (see
+// MVCCStore.BeginStartTs for the mode that has one) ties everything to Txid
+// instead. Lower values are older and win wound-wait conflicts.
+func (kv *KVService) ageOf(txid uint64) uint64 {
+	if v, found := kv.txnAge.Load(txid); found {
+		return v.(uint64)
+	}
+	age := atomic.AddUint64(&kv.ageCounter, 1)
+	actual, _ := kv.txnAge.LoadOrStore(txid, age)
+	return actual.(uint64)
+}
+
+// wound forcibly aborts txid after it lost a wound-wait race to an older
+// requester: its locks are released right away (waking whoever was queued
+// behind it) and it's flagged in kv.wounded so its next Get/Put reports the
+// abort, instead of silently continuing on a transaction that no longer
+// holds what it thinks it holds.
+func (kv *KVService) wound(txid uint64) {
+	kv.wounded.Store(txid, true)
+	kv.releaseLocks(txid)
+	atomic.AddUint64(&kv.stats.aborts, 1)
+}
+
+// acquireLock blocks txid's request for mode ("read" or "write") on key
+// until it's granted, times out, or txid itself gets wounded.
+//
+// Conflicts are resolved by wound-wait instead of the blind "abort whoever
+// showed up second" the 2PL path used to do: if txid is older (see ageOf)
+// than every holder it conflicts with, those holders are wounded and txid
+// takes the lock right away; otherwise txid queues FIFO behind the
+// conflict. Only the current holders' ages are compared: if txid conflicts
+// with several read holders and any one of them is older, txid queues
+// behind all of them, younger ones included, and a queued request also
+// waits behind every request queued ahead of it whatever their ages. So a
+// wait cycle is not ruled out on a single shard; a waiting request gives
+// up after kv.lockTimeout.
+func (kv *KVService) acquireLock(key string, txid uint64, mode string) error {
+	lockInfoVal, _ := kv.locks.LoadOrStore(key, NewLockInfo())
+	lockInfo := lockInfoVal.(*LockInfo)
+	age := kv.ageOf(txid)
+
+	for {
+		lockInfo.mu.Lock()
+
+		conflicts := conflictingHolders(lockInfo, txid, mode)
+		if len(conflicts) == 0 {
+			grantLock(lockInfo, txid, mode)
+			lockInfo.mu.Unlock()
+			return nil
+		}
+
+		olderThanAll := true
+		for _, holder := range conflicts {
+			if kv.ageOf(holder) <= age {
+				olderThanAll = false
+				break
+			}
+		}
+		if olderThanAll {
+			lockInfo.mu.Unlock()
+			for _, holder := range conflicts {
+				kv.wound(holder)
+			}
+			continue // conflicting holders are gone now; re-check from the top
+		}
+
+		w := &waiter{txid: txid, mode: mode, done: make(chan error, 1)}
+		lockInfo.waiters = append(lockInfo.waiters, w)
+		lockInfo.mu.Unlock()
+
+		select {
+		case err := <-w.done:
+			return err
+		case <-time.After(kv.lockTimeout):
+			lockInfo.mu.Lock()
+			for i, queued := range lockInfo.waiters {
+				if queued == w {
+					lockInfo.waiters = append(lockInfo.waiters[:i], lockInfo.waiters[i+1:]...)
+					break
+				}
+			}
+			lockInfo.mu.Unlock()
+			modeLabel := "Read"
+			if mode == "write" {
+				modeLabel = "Write"
+			}
+			return fmt.Errorf("Cannot acquire %s Lock, timed out waiting for key %q", modeLabel, key)
+		}
+	}
+}
+
+// acquireReadLock attempts to acquire a read lock for the given transaction on the given key
+func (kv *KVService) acquireReadLock(key string, txid uint64) error {
+	return kv.acquireLock(key, txid, "read")
+}
+
+// acquireWriteLock attempts to acquire a write lock for the given transaction on the given key
+func (kv *KVService) acquireWriteLock(key string, txid uint64) error {
+	return kv.acquireLock(key, txid, "write")
+}
+
+// tryAcquireLock is acquireLock's non-blocking sibling: a conflict fails
+// immediately instead of queuing. Used by the Raft apply loop (see
+// kvs/server/raftkv.go), where committed commands are applied one at a
+// time in log order - blocking there would deadlock against the very later
+// Commit/Abort command that would free the lock, since nothing else can run
+// on that goroutine to release it first.
+func (kv *KVService) tryAcquireLock(key string, txid uint64, mode string) error {
+	lockInfoVal, _ := kv.locks.LoadOrStore(key, NewLockInfo())
+	lockInfo := lockInfoVal.(*LockInfo)
+
+	lockInfo.mu.Lock()
+	defer lockInfo.mu.Unlock()
+
+	if conflicts := conflictingHolders(lockInfo, txid, mode); len(conflicts) > 0 {
+		modeLabel := "Read"
+		if mode == "write" {
+			modeLabel = "Write"
+		}
+		return fmt.Errorf("Cannot acquire %s Lock, key %q held by a conflicting transaction", modeLabel, key)
+	}
+	grantLock(lockInfo, txid, mode)
+	return nil
+}
+
+func (kv *KVService) tryAcquireReadLock(key string, txid uint64) error {
+	return kv.tryAcquireLock(key, txid, "read")
+}
+
+func (kv *KVService) tryAcquireWriteLock(key string, txid uint64) error {
+	return kv.tryAcquireLock(key, txid, "write")
+}
+
+// releaseKeyLock drops txid's read/write hold on a single key, if any, and
+// wakes whichever queued waiters that makes eligible.
+func (kv *KVService) releaseKeyLock(key string, txid uint64) {
+	if lockInfoVal, found := kv.locks.Load(key); found {
+		lockInfo := lockInfoVal.(*LockInfo)
+		lockInfo.mu.Lock()
+		delete(lockInfo.readHolders, txid)
+		if lockInfo.writeHolder != nil && *lockInfo.writeHolder == txid {
+			lockInfo.writeHolder = nil
+		}
+		wakeWaiters(lockInfo)
+		lockInfo.mu.Unlock()
+	}
+}
+
+// waitingOn returns the txids txid is currently queued behind on this
+// shard: for every key where it's sitting in LockInfo.waiters, every
+// current holder of that key. Backs the WaitingOn RPC, which kvs/client's
+// deadlock detector polls across every shard a transaction has touched to
+// assemble a global waits-for graph - see kvs.WaitingOnRequest.
+func (kv *KVService) waitingOn(txid uint64) []uint64 {
+	holders := make(map[uint64]bool)
+	kv.locks.Range(func(_, v interface{}) bool {
+		lockInfo := v.(*LockInfo)
+		lockInfo.mu.Lock()
+		queued := false
+		for _, w := range lockInfo.waiters {
+			if w.txid == txid {
+				queued = true
+				break
+			}
+		}
+		if queued {
+			for holder := range lockInfo.readHolders {
+				holders[holder] = true
+			}
+			if lockInfo.writeHolder != nil {
+				holders[*lockInfo.writeHolder] = true
+			}
+		}
+		lockInfo.mu.Unlock()
+		return true
+	})
+
+	result := make([]uint64, 0, len(holders))
+	for holder := range holders {
+		result = append(result, holder)
+	}
+	return result
+}
+
+// killAsDeadlockVictim force-aborts txid after kvs/client's deadlock
+// detector picked it to break a cross-shard waits-for cycle (see
+// waitingOn and kvs.KillDeadlockVictimRequest): any lock it already holds
+// is released, same as wound, but it's also pulled out of any wait queue
+// it's currently sitting in right away, instead of letting that wait run
+// out its normal lockTimeout - and it's flagged in kv.deadlockVictims, not
+// kv.wounded, so its next Get/Put reports the distinct ErrDeadlockVictim
+// instead of an ordinary wound-wait abort.
+func (kv *KVService) killAsDeadlockVictim(txid uint64) {
+	kv.deadlockVictims.Store(txid, true)
+
+	kv.locks.Range(func(_, v interface{}) bool {
+		lockInfo := v.(*LockInfo)
+		lockInfo.mu.Lock()
+		for i, w := range lockInfo.waiters {
+			if w.txid == txid {
+				lockInfo.waiters = append(lockInfo.waiters[:i], lockInfo.waiters[i+1:]...)
+				w.done <- fmt.Errorf("Deadlock: txn %d aborted to break a wait cycle", txid)
+				break
+			}
+		}
+		lockInfo.mu.Unlock()
+		return true
+	})
+
+	kv.releaseLocks(txid)
+	atomic.AddUint64(&kv.stats.aborts, 1)
+}
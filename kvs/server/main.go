@@ -8,11 +8,14 @@ import (
 	"net"
 	"net/http"
 	"net/rpc"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/rstutsman/cs6450-labs/kvs"
+	"github.com/rstutsman/cs6450-labs/kvs/raftkv"
 )
 
 type Stats struct {
@@ -37,88 +40,143 @@ type Operation struct {
 	Value  string // empty for GET operations
 }
 
-// tracks lock holders for a specific "key"
-type LockInfo struct {
-	readHolders map[uint64]bool // Set of transactions holding read locks
-	writeHolder *uint64         // Transaction holding write lock (nil if none)
+// VersionedValue is what kv.mp actually stores per key: the committed value
+// plus a version counter bumped on every write, so CAS-style conditional
+// commits (see ConditionalCommit) can compare against a specific version
+// instead of racing on the raw value.
+type VersionedValue struct {
+	Value   string
+	Version uint64
 }
 
-func NewLockInfo() *LockInfo {
-	return &LockInfo{
-		readHolders: make(map[uint64]bool),
-		writeHolder: nil,
+// encodeVersionedValue/decodeVersionedValue let kv.mp's SkipList, which only
+// stores plain strings (see skiplist.go), hold a VersionedValue: the version
+// goes first as decimal text, a NUL separates it from the value, and only
+// the first NUL is treated as the separator so a value containing NUL bytes
+// of its own still round-trips.
+func encodeVersionedValue(v VersionedValue) string {
+	return strconv.FormatUint(v.Version, 10) + "\x00" + v.Value
+}
+
+func decodeVersionedValue(encoded string) VersionedValue {
+	i := strings.IndexByte(encoded, 0)
+	version, _ := strconv.ParseUint(encoded[:i], 10, 64)
+	return VersionedValue{Value: encoded[i+1:], Version: version}
+}
+
+// loadValue reads the current value+version for key, returning the zero
+// value if the key has never been written.
+func (kv *KVService) loadValue(key string) VersionedValue {
+	if k, v, ok := kv.mp.Seek([]byte(key)); ok && string(k) == key {
+		return decodeVersionedValue(v)
 	}
+	return VersionedValue{}
+}
+
+// storeValue writes value under key, bumping its version.
+func (kv *KVService) storeValue(key string, value string) VersionedValue {
+	next := VersionedValue{Value: value, Version: kv.loadValue(key).Version + 1}
+	kv.mp.Insert([]byte(key), encodeVersionedValue(next))
+	return next
 }
 
 type KVService struct {
-	sync.RWMutex          // embedded, KVService "inherits" all methods of sync.RWMutex (even though go has no inheritence feature)
-	mp           sync.Map // map[string]string - actual key-value store
-	locks        sync.Map // map[string]*LockInfo - lock management; 追蹤每個 key 被哪些 transaction 鎖定
-	transactions sync.Map // map[uint64][]Operation - transaction operations	txn id: ops[]
+	sync.RWMutex           // embedded, KVService "inherits" all methods of sync.RWMutex (even though go has no inheritence feature)
+	mp           *SkipList // actual key-value store, ordered so Scan can do range queries (see skiplist.go)
+	locks        sync.Map  // map[string]*LockInfo - lock management; 追蹤每個 key 被哪些 transaction 鎖定
+	transactions sync.Map  // map[uint64][]Operation - transaction operations	txn id: ops[]
 	stats        Stats
 	prevStats    Stats
 	lastPrint    time.Time
+
+	// ageCounter/txnAge/wounded back wound-wait deadlock avoidance for the
+	// lock manager in lockmanager.go: ageCounter hands out the next "age"
+	// the first time a txid is seen, txnAge remembers it, and wounded marks
+	// a txid that lost a wound-wait race so its next Get/Put reports the
+	// abort instead of proceeding on locks that were already stripped away.
+	ageCounter  uint64
+	txnAge      sync.Map // map[uint64]uint64 - txid -> age (lower = older)
+	wounded     sync.Map // map[uint64]bool
+	lockTimeout time.Duration
+
+	// deadlockVictims marks a txid killAsDeadlockVictim force-aborted
+	// because kvs/client's deadlock detector found it in a cross-shard
+	// waits-for cycle - kept separate from wounded so Get/Put can report
+	// the more specific ErrDeadlockVictim instead of an ordinary
+	// wound-wait abort. See waitingOn/killAsDeadlockVictim in
+	// lockmanager.go.
+	deadlockVictims sync.Map // map[uint64]bool
+
+	// mvcc is non-nil when the server was started with -cc=mvcc, in which
+	// case Get/Put/Commit are routed through it instead of the 2PL path
+	// above. Kept as a separate engine rather than threaded through the
+	// existing lock/mp fields so the two concurrency-control modes can't
+	// accidentally interact.
+	mvcc        *MVCCStore
+	mvccPrimary sync.Map // map[uint64(startTs)]string - primary key chosen for each in-flight MVCC txn
+
+	// wal and decisions back the cross-shard 2PC protocol: wal durably
+	// records PREPARE/COMMIT/ABORT so a crashed process can recover, and
+	// decisions caches the final COMMIT/ABORT outcome in memory so Status
+	// can answer a recovering participant without re-reading the log.
+	wal       *WAL
+	decisions sync.Map // map[uint64]string - txid -> "COMMIT"/"ABORT"
+
+	// selfAddr is this shard's own host:port, set from -self-addr in main()
+	// (default localhost:<port>). It
+	// lets resolvePreparedTxn tell "I am this transaction's primary" apart
+	// from "the primary is some other, still-reachable shard" - a PREPARE
+	// record's PrimaryAddr is never empty (every participant, including the
+	// primary itself, is told the primary's address), so that distinction
+	// can't be made from primaryAddr alone.
+	selfAddr string
+
+	// raft is non-nil when this shard was started with -replicas, in which
+	// case Get/Put/Commit/Abort are proposed through it instead of applied
+	// directly - see kvs/server/raftkv.go. raftApplyCh feeds raftApplyLoop,
+	// raftNotify wakes whichever RPC handler is waiting on a given log
+	// index, and raftDedup remembers each client's last applied (SeqNum,
+	// result) so a retried RPC isn't double-applied.
+	raft        *raftkv.Raft
+	raftApplyCh chan raftkv.ApplyMsg
+	raftNotify  sync.Map // map[int]chan raftResult - log index -> waiter
+	raftDedup   sync.Map // map[uint64]dedupEntry - ClientId -> last (SeqNum, result)
 }
 
 func NewKVService() *KVService {
 	// Don't need to initialize sync.Map vars because they are available empty map; zero value of RWMutex is also available un-lock state
 	// Stats is consist of uint64 whose zero value is zero
 	kvs := &KVService{} // kvs is a pointer
+	kvs.mp = NewSkipList()
 	kvs.lastPrint = time.Now()
+	kvs.lockTimeout = defaultLockTimeout
 	return kvs // return pointer
 }
 
-// acquireReadLock attempts to acquire a read lock for the given transaction on the given key
-func (kv *KVService) acquireReadLock(key string, txid uint64) error {
-	lockInfoVal, _ := kv.locks.LoadOrStore(key, NewLockInfo()) // return value(type: any), loaded(bool)
-	// sync.Map is a generic container (an implementation from before generics were introduced) -> 取出時，編譯器不知道具體類型，只知道是 any
-	lockInfo := lockInfoVal.(*LockInfo) // I know lockInfoVal contains *LockInfo, convert to this type. If assertion failed（wrong type), get panic
-
-	// Check if there's a write lock held by a different transaction
-	if lockInfo.writeHolder != nil && *lockInfo.writeHolder != txid {
-		return errors.New("Cannot acquire Read Lock, key is currently write locked") // returns an error that formats as the given text.
-	}
-
-	// If this transaction already holds the write lock, it can also read
-	if lockInfo.writeHolder != nil && *lockInfo.writeHolder == txid {
-		return nil // Already has write lock, which includes read access
-	}
-
-	// No other transactions on the given key have lock
-	lockInfo.readHolders[txid] = true
-	return nil
+// NewMVCCKVService builds a KVService running in snapshot-isolation mode
+// (-cc=mvcc) instead of the default per-key 2PL above.
+func NewMVCCKVService() *KVService {
+	kvs := NewKVService()
+	kvs.mvcc = NewMVCCStore()
+	return kvs
 }
 
-// acquireWriteLock attempts to acquire a write lock for the given transaction on the given key
-func (kv *KVService) acquireWriteLock(key string, txid uint64) error {
-	lockInfoVal, _ := kv.locks.LoadOrStore(key, NewLockInfo())
-	lockInfo := lockInfoVal.(*LockInfo)
+// acquireReadLock, acquireWriteLock, and releaseKeyLock now live in
+// lockmanager.go along with the wound-wait wait-queue they're built on.
 
-	// If this transaction already holds the write lock, allow it
-	if lockInfo.writeHolder != nil && *lockInfo.writeHolder == txid {
-		return nil
-	}
-
-	// If another transaction holds the write lock, deny
-	if lockInfo.writeHolder != nil {
-		return errors.New("Cannot acquire Write Lock, key is currently write locked")
-	}
-
-	// If there are read locks held by other transactions, deny
-	// Exception: if only this transaction holds a read lock, allow upgrade
-	if len(lockInfo.readHolders) > 1 {
-		return errors.New("Cannot acquire Write Lock, key has multiple read locks")
+// abortedReason reports why txid can no longer proceed, if it can't:
+// "Deadlock: ..." if kvs/client's deadlock detector killed it to break a
+// cross-shard waits-for cycle (see kvs/server/lockmanager.go's
+// killAsDeadlockVictim), "Abort: ..." if an older transaction wounded it
+// (see wound), or ("", false) if neither applies.
+func (kv *KVService) abortedReason(txid uint64) (string, bool) {
+	if _, victim := kv.deadlockVictims.Load(txid); victim {
+		return fmt.Sprintf("Deadlock: txn %d aborted to break a wait cycle", txid), true
 	}
-	if len(lockInfo.readHolders) == 1 && !lockInfo.readHolders[txid] {
-		return errors.New("Cannot acquire Write Lock, key is read locked by another transaction")
+	if _, wounded := kv.wounded.Load(txid); wounded {
+		return fmt.Sprintf("Abort: txn %d was wounded by an older transaction", txid), true
 	}
-
-	// Acquire write lock
-	lockInfo.writeHolder = &txid
-	// Remove from read holders if it was there (lock upgrade case)
-	delete(lockInfo.readHolders, txid)
-
-	return nil
+	return "", false
 }
 
 func (kv *KVService) releaseLocks(txid uint64) {
@@ -126,17 +184,7 @@ func (kv *KVService) releaseLocks(txid uint64) {
 	if ops, found := kv.transactions.Load(txid); found { // return interface
 		if operations, ok := ops.([]Operation); ok { // convert ops to []Operations
 			for _, op := range operations {
-				if lockInfoVal, found := kv.locks.Load(op.Key); found { // return interface
-					lockInfo := lockInfoVal.(*LockInfo) // convert lockInfoVal to *LockInfo
-
-					// remove share locks associated with the txid
-					delete(lockInfo.readHolders, txid) // func delete(m map[Type]Type1, key Type)
-
-					// remove exclusive lock
-					if lockInfo.writeHolder != nil && *lockInfo.writeHolder == txid {
-						lockInfo.writeHolder = nil
-					}
-				}
+				kv.releaseKeyLock(op.Key, txid)
 			}
 		}
 	}
@@ -152,14 +200,65 @@ func (kv *KVService) releaseLocks(txid uint64) {
 	kv.transactions.Delete(txid)
 }
 
+// Begin hands out a fresh MVCC snapshot timestamp. Only valid in -cc=mvcc
+// mode; 2PL mode has no server-side notion of start_ts.
+func (kv *KVService) Begin(request *kvs.BeginRequest, response *kvs.BeginResponse) error {
+	if kv.mvcc == nil {
+		return errors.New("Begin: server is not running in -cc=mvcc mode")
+	}
+	response.StartTs = kv.mvcc.BeginStartTs()
+	return nil
+}
+
+// Prewrite stages a buffered write under a lock, the first phase of an MVCC
+// commit (see kvs/server/mvcc.go). Only valid in -cc=mvcc mode.
+func (kv *KVService) Prewrite(request *kvs.PrewriteRequest, response *kvs.PrewriteResponse) error {
+	if kv.mvcc == nil {
+		return errors.New("Prewrite: server is not running in -cc=mvcc mode")
+	}
+	if err := kv.mvcc.Prewrite(request.Key, request.Value, request.StartTs, request.Primary); err != nil {
+		atomic.AddUint64(&kv.stats.aborts, 1)
+		return err
+	}
+	atomic.AddUint64(&kv.stats.puts, 1)
+	return nil
+}
+
 func (kv *KVService) Get(request *kvs.GetRequest, response *kvs.GetResponse) error {
-	kv.Lock()
-	defer kv.Unlock()
+	if kv.mvcc != nil {
+		value, err := kv.mvcc.Get(request.Key, request.StartTs)
+		if err != nil {
+			atomic.AddUint64(&kv.stats.aborts, 1)
+			return err
+		}
+		response.Value = value
+		atomic.AddUint64(&kv.stats.gets, 1)
+		return nil
+	}
+
+	if kv.raft != nil {
+		result, err := kv.proposeRaft(raftCommand{Op: "GET", Key: request.Key, Txid: request.Txid, ClientId: request.ClientId, SeqNum: request.SeqNum})
+		if err != nil {
+			return err
+		}
+		response.Value = result.Value
+		return nil
+	}
 
+	if reason, aborted := kv.abortedReason(request.Txid); aborted {
+		atomic.AddUint64(&kv.stats.aborts, 1)
+		return errors.New(reason)
+	}
+
+	kv.Lock()
 	if _, found := kv.transactions.Load(request.Txid); !found {
 		kv.transactions.Store(request.Txid, make([]Operation, 0, 4))
 	}
+	kv.Unlock()
 
+	// acquireReadLock may block in a wait-queue, so it must run with no lock
+	// of ours held - otherwise a holder's eventual Commit/Abort, which needs
+	// kv.Lock() too, could never run to release it. See lockmanager.go.
 	err := kv.acquireReadLock(request.Key, request.Txid)
 	if err != nil { // acquireReadLock return error only if another transaction holds "write lock" (Read-write conflict)
 		kv.releaseLocks(request.Txid)         // realease ALL locks associated with this txn since we are gonna abort
@@ -167,6 +266,7 @@ func (kv *KVService) Get(request *kvs.GetRequest, response *kvs.GetResponse) err
 		return err
 	}
 
+	kv.Lock()
 	// Add operation to transaction log. These ops must be atomic!
 	ops, _ := kv.transactions.Load(request.Txid)
 	operations := ops.([]Operation)
@@ -178,24 +278,54 @@ func (kv *KVService) Get(request *kvs.GetRequest, response *kvs.GetResponse) err
 	// Above ops must be atomic!
 
 	// Read the value from up right away
-	if value, found := kv.mp.Load(request.Key); found {
-		response.Value = value.(string)
-	} else { // Key doesn't exist
-		response.Value = ""
-	}
+	current := kv.loadValue(request.Key)
+	response.Value = current.Value
+	response.Version = current.Version
+	kv.Unlock()
 
 	atomic.AddUint64(&kv.stats.gets, 1)
 	return nil
 }
 
 func (kv *KVService) Put(request *kvs.PutRequest, response *kvs.PutResponse) error {
-	kv.Lock()
-	defer kv.Unlock()
+	if kv.mvcc != nil {
+		// The txn's first write picks the primary key, per the percolator
+		// scheme: its lock is the source of truth for whether the whole
+		// transaction committed (see mvcc.go's checkLock/resolve path).
+		primaryVal, _ := kv.mvccPrimary.LoadOrStore(request.StartTs, request.Key)
+		primary := primaryVal.(string)
+
+		if err := kv.mvcc.Prewrite(request.Key, request.Value, request.StartTs, primary); err != nil {
+			atomic.AddUint64(&kv.stats.aborts, 1)
+			return err
+		}
+
+		ops, _ := kv.transactions.LoadOrStore(request.StartTs, make([]Operation, 0, 4))
+		operations := append(ops.([]Operation), Operation{OpType: "PUT", Key: request.Key, Value: request.Value})
+		kv.transactions.Store(request.StartTs, operations)
+
+		atomic.AddUint64(&kv.stats.puts, 1)
+		return nil
+	}
 
+	if kv.raft != nil {
+		_, err := kv.proposeRaft(raftCommand{Op: "PUT", Key: request.Key, Value: request.Value, Txid: request.Txid, ClientId: request.ClientId, SeqNum: request.SeqNum})
+		return err
+	}
+
+	if reason, aborted := kv.abortedReason(request.Txid); aborted {
+		atomic.AddUint64(&kv.stats.aborts, 1)
+		return errors.New(reason)
+	}
+
+	kv.Lock()
 	if _, found := kv.transactions.Load(request.Txid); !found {
 		kv.transactions.Store(request.Txid, make([]Operation, 0, 4))
 	}
+	kv.Unlock()
 
+	// See the matching comment in Get: must not hold kv.Lock() while this
+	// can block.
 	err := kv.acquireWriteLock(request.Key, request.Txid)
 	if err != nil { // Write-write conflict
 		kv.releaseLocks(request.Txid)
@@ -203,6 +333,7 @@ func (kv *KVService) Put(request *kvs.PutRequest, response *kvs.PutResponse) err
 		return err
 	}
 
+	kv.Lock()
 	// Add operation to transaction log (buffered, will be applied on commit)
 	ops, _ := kv.transactions.Load(request.Txid)
 	operations := ops.([]Operation)
@@ -213,6 +344,7 @@ func (kv *KVService) Put(request *kvs.PutRequest, response *kvs.PutResponse) err
 	})
 	kv.transactions.Store(request.Txid, operations)
 	// No modification on mp yet -> No kv.mp.Store()
+	kv.Unlock()
 
 	atomic.AddUint64(&kv.stats.puts, 1)
 	return nil
@@ -220,14 +352,54 @@ func (kv *KVService) Put(request *kvs.PutRequest, response *kvs.PutResponse) err
 
 // Commit applies all PUT operations from the transaction, then releases locks
 func (kv *KVService) Commit(request *kvs.CommitRequest, response *kvs.CommitResponse) error {
+	if kv.mvcc != nil {
+		commitTs := request.CommitTs
+		if commitTs == 0 {
+			commitTs = kv.mvcc.BeginStartTs() // participant assigns its own commit_ts if the coordinator didn't pick one
+		}
+
+		if operations, found := kv.transactions.Load(request.StartTs); found {
+			for _, op := range operations.([]Operation) {
+				if op.OpType == "PUT" {
+					if err := kv.mvcc.Commit(op.Key, request.StartTs, commitTs); err != nil {
+						atomic.AddUint64(&kv.stats.aborts, 1)
+						return err
+					}
+				}
+			}
+			if request.Lead {
+				atomic.AddUint64(&kv.stats.commits, 1)
+			}
+		}
+		kv.transactions.Delete(request.StartTs)
+		kv.mvccPrimary.Delete(request.StartTs)
+		return nil
+	}
+
+	if kv.raft != nil {
+		_, err := kv.proposeRaft(raftCommand{Op: "COMMIT", Txid: request.Txid, Lead: request.Lead, ClientId: request.ClientId, SeqNum: request.SeqNum})
+		return err
+	}
+
 	kv.Lock()
 	defer kv.Unlock()
 
+	if kv.wal != nil {
+		if err := kv.wal.Append(WALRecord{Decision: "COMMIT", Txid: request.Txid}); err != nil {
+			return err
+		}
+	}
+	kv.decisions.Store(request.Txid, "COMMIT")
+
 	if operations, found := kv.transactions.Load(request.Txid); found {
 		if ops, ok := operations.([]Operation); ok {
 			for _, op := range ops { // Apply all PUT operations
 				if op.OpType == "PUT" {
-					kv.mp.Store(op.Key, op.Value)
+					versioned := kv.storeValue(op.Key, op.Value)
+					if response.Versions == nil {
+						response.Versions = make(map[string]uint64)
+					}
+					response.Versions[op.Key] = versioned.Version
 				}
 			}
 
@@ -244,14 +416,542 @@ func (kv *KVService) Commit(request *kvs.CommitRequest, response *kvs.CommitResp
 
 // Abort discards all operations and releases locks
 func (kv *KVService) Abort(request *kvs.AbortRequest, response *kvs.AbortResponse) error {
+	if kv.mvcc != nil {
+		if operations, found := kv.transactions.Load(request.StartTs); found {
+			for _, op := range operations.([]Operation) {
+				if op.OpType == "PUT" {
+					kv.mvcc.Rollback(op.Key, request.StartTs)
+				}
+			}
+		}
+		kv.transactions.Delete(request.StartTs)
+		kv.mvccPrimary.Delete(request.StartTs)
+		atomic.AddUint64(&kv.stats.aborts, 1)
+		return nil
+	}
+
+	if kv.raft != nil {
+		_, err := kv.proposeRaft(raftCommand{Op: "ABORT", Txid: request.Txid, ClientId: request.ClientId, SeqNum: request.SeqNum})
+		return err
+	}
+
 	// kv.Lock()
 	// defer kv.Unlock()
 
+	if kv.wal != nil {
+		if err := kv.wal.Append(WALRecord{Decision: "ABORT", Txid: request.Txid}); err != nil {
+			log.Printf("Abort: failed to log decision for txn %d: %v", request.Txid, err)
+		}
+	}
+	kv.decisions.Store(request.Txid, "ABORT")
+
 	kv.releaseLocks(request.Txid)
 	atomic.AddUint64(&kv.stats.aborts, 1)
 	return nil
 }
 
+// Prepare is phase one of cross-shard 2PC. By the time a coordinator calls
+// this, every Get/Put in the transaction has already acquired its locks
+// (the existing 2PL code above does that eagerly, aborting on conflict), so
+// reaching here with buffered ops at all means this participant can commit.
+// Prepare's only remaining job is to make that durable, so a crash after
+// voting yes still lets this participant honor the coordinator's decision.
+func (kv *KVService) Prepare(request *kvs.PrepareRequest, response *kvs.PrepareResponse) error {
+	kv.Lock()
+	defer kv.Unlock()
+
+	ops, found := kv.transactions.Load(request.Txid)
+	if !found {
+		response.Vote = false
+		return nil
+	}
+
+	if kv.wal != nil {
+		record := WALRecord{Decision: "PREPARE", Txid: request.Txid, Ops: ops.([]Operation), PrimaryAddr: request.PrimaryAddr}
+		if err := kv.wal.Append(record); err != nil {
+			response.Vote = false
+			return err
+		}
+	}
+
+	response.Vote = true
+	return nil
+}
+
+// Status answers a participant that lost contact with its coordinator and
+// is asking this shard (presumably the elected primary for that txid) for
+// the transaction's final decision.
+func (kv *KVService) Status(request *kvs.StatusRequest, response *kvs.StatusResponse) error {
+	if decision, found := kv.decisions.Load(request.Txid); found {
+		response.Decision = decision.(string)
+	}
+	return nil
+}
+
+// ReadOnlyTxn serves a batch of reads without any of the machinery a
+// read/write transaction needs - no per-key lock, no transactions log
+// entry, no later Commit/Abort call. Because writers only publish into mp
+// (or, in MVCC mode, into a new version) once they hold the commit lock,
+// a plain snapshot read here can never observe a half-applied write, so
+// in 2PL mode there's nothing for a concurrent writer to abort. In MVCC
+// mode a key still locked by an older prewrite whose primary is unresolved
+// makes this return mvcc.Get's lock-conflict error once checkLock's wait
+// runs out.
+func (kv *KVService) ReadOnlyTxn(request *kvs.ReadOnlyTxnRequest, response *kvs.ReadOnlyTxnResponse) error {
+	response.Values = make([]string, len(request.Keys))
+
+	if kv.mvcc != nil {
+		snapshotTs := kv.mvcc.BeginStartTs() // every key in this batch is read at the same snapshot
+		for i, key := range request.Keys {
+			value, err := kv.mvcc.Get(key, snapshotTs)
+			if err != nil {
+				return err
+			}
+			response.Values[i] = value
+		}
+		atomic.AddUint64(&kv.stats.gets, uint64(len(request.Keys)))
+		return nil
+	}
+
+	kv.RLock()
+	defer kv.RUnlock()
+	for i, key := range request.Keys {
+		response.Values[i] = kv.loadValue(key).Value
+	}
+	atomic.AddUint64(&kv.stats.gets, uint64(len(request.Keys)))
+	return nil
+}
+
+// BatchGet reads multiple keys from this shard in a single RPC, each under a
+// read lock recorded in the transaction's op log just like an individual
+// Get - the locked-path counterpart to ReadOnlyTxn, which skips locking (and
+// the whole Commit/Abort lifecycle) entirely.
+func (kv *KVService) BatchGet(request *kvs.BatchGetRequest, response *kvs.BatchGetResponse) error {
+	if kv.raft != nil {
+		return errNotReplicated
+	}
+
+	if reason, aborted := kv.abortedReason(request.Txid); aborted {
+		atomic.AddUint64(&kv.stats.aborts, 1)
+		return errors.New(reason)
+	}
+
+	kv.Lock()
+	if _, found := kv.transactions.Load(request.Txid); !found {
+		kv.transactions.Store(request.Txid, make([]Operation, 0, len(request.Keys)))
+	}
+	kv.Unlock()
+
+	// Track which keys this call acquired so a mid-batch conflict can drop
+	// them again before releaseLocks aborts the rest of the transaction -
+	// they haven't been appended to kv.transactions yet, so releaseLocks
+	// alone wouldn't find them.
+	var lockedKeys []string
+	for _, key := range request.Keys {
+		// See the matching comment in Get: must not hold kv.Lock() while this
+		// can block.
+		if err := kv.acquireReadLock(key, request.Txid); err != nil {
+			for _, locked := range lockedKeys {
+				kv.releaseKeyLock(locked, request.Txid)
+			}
+			kv.releaseLocks(request.Txid)
+			atomic.AddUint64(&kv.stats.aborts, 1)
+			return err
+		}
+		lockedKeys = append(lockedKeys, key)
+	}
+
+	response.Values = make([]string, len(request.Keys))
+	kv.Lock()
+	ops, _ := kv.transactions.Load(request.Txid)
+	operations := ops.([]Operation)
+	for i, key := range request.Keys {
+		operations = append(operations, Operation{OpType: "GET", Key: key})
+		response.Values[i] = kv.loadValue(key).Value
+	}
+	kv.transactions.Store(request.Txid, operations)
+	kv.Unlock()
+
+	atomic.AddUint64(&kv.stats.gets, uint64(len(request.Keys)))
+	return nil
+}
+
+// BatchPut buffers multiple writes on this shard in a single RPC, each under
+// a write lock recorded in the transaction's op log, exactly like
+// individual Puts would be - nothing is applied to mp until Commit.
+func (kv *KVService) BatchPut(request *kvs.BatchPutRequest, response *kvs.BatchPutResponse) error {
+	if kv.raft != nil {
+		return errNotReplicated
+	}
+
+	if reason, aborted := kv.abortedReason(request.Txid); aborted {
+		atomic.AddUint64(&kv.stats.aborts, 1)
+		return errors.New(reason)
+	}
+
+	kv.Lock()
+	if _, found := kv.transactions.Load(request.Txid); !found {
+		kv.transactions.Store(request.Txid, make([]Operation, 0, len(request.Keys)))
+	}
+	kv.Unlock()
+
+	var lockedKeys []string
+	for _, key := range request.Keys {
+		if err := kv.acquireWriteLock(key, request.Txid); err != nil {
+			for _, locked := range lockedKeys {
+				kv.releaseKeyLock(locked, request.Txid)
+			}
+			kv.releaseLocks(request.Txid)
+			atomic.AddUint64(&kv.stats.aborts, 1)
+			return err
+		}
+		lockedKeys = append(lockedKeys, key)
+	}
+
+	kv.Lock()
+	ops, _ := kv.transactions.Load(request.Txid)
+	operations := ops.([]Operation)
+	for i, key := range request.Keys {
+		operations = append(operations, Operation{OpType: "PUT", Key: key, Value: request.Values[i]})
+	}
+	kv.transactions.Store(request.Txid, operations)
+	// No modification on mp yet -> applied on Commit, same as Put.
+	kv.Unlock()
+
+	atomic.AddUint64(&kv.stats.puts, uint64(len(request.Keys)))
+	return nil
+}
+
+// Scan returns up to Limit keys in [StartKey, EndKey) from this shard, each
+// under a read lock recorded in the transaction's op log just like an
+// individual Get. The candidate key list is gathered from kv.mp with no
+// kv.Lock() held (mp's own mutex covers that walk), then each candidate is
+// locked and re-read so the reported value matches what the lock actually
+// protects. Accepted simplification: a key written into the range between
+// the walk and the lock simply won't show up in this Scan - there's no
+// phantom protection here, same as the rest of this 2PL implementation only
+// locks keys it was actually asked about.
+func (kv *KVService) Scan(request *kvs.ScanRequest, response *kvs.ScanResponse) error {
+	if kv.raft != nil {
+		return errNotReplicated
+	}
+
+	if reason, aborted := kv.abortedReason(request.Txid); aborted {
+		atomic.AddUint64(&kv.stats.aborts, 1)
+		return errors.New(reason)
+	}
+
+	kv.Lock()
+	if _, found := kv.transactions.Load(request.Txid); !found {
+		kv.transactions.Store(request.Txid, make([]Operation, 0, 4))
+	}
+	kv.Unlock()
+
+	var start, end []byte
+	if request.StartKey != "" {
+		start = []byte(request.StartKey)
+	}
+	if request.EndKey != "" {
+		end = []byte(request.EndKey)
+	}
+
+	var candidates []string
+	kv.mp.Range(start, end, func(key []byte, _ string) bool {
+		candidates = append(candidates, string(key))
+		return request.Limit <= 0 || len(candidates) < request.Limit
+	})
+
+	var lockedKeys []string
+	for _, key := range candidates {
+		if err := kv.acquireReadLock(key, request.Txid); err != nil {
+			for _, locked := range lockedKeys {
+				kv.releaseKeyLock(locked, request.Txid)
+			}
+			kv.releaseLocks(request.Txid)
+			atomic.AddUint64(&kv.stats.aborts, 1)
+			return err
+		}
+		lockedKeys = append(lockedKeys, key)
+	}
+
+	response.Keys = make([]string, len(candidates))
+	response.Values = make([]string, len(candidates))
+	response.Versions = make([]uint64, len(candidates))
+	kv.Lock()
+	ops, _ := kv.transactions.Load(request.Txid)
+	operations := ops.([]Operation)
+	for i, key := range candidates {
+		operations = append(operations, Operation{OpType: "GET", Key: key})
+		current := kv.loadValue(key)
+		response.Keys[i] = key
+		response.Values[i] = current.Value
+		response.Versions[i] = current.Version
+	}
+	kv.transactions.Store(request.Txid, operations)
+	kv.Unlock()
+
+	atomic.AddUint64(&kv.stats.gets, uint64(len(candidates)))
+	return nil
+}
+
+// WaitingOn reports which other transactions request.Txid is currently
+// queued behind on this shard (empty if it isn't waiting on anything
+// here). kvs/client's deadlock detector polls this across every shard a
+// transaction has touched to assemble a global waits-for graph - see
+// waitingOn in lockmanager.go. Only meaningful in 2PL mode; MVCC and
+// Raft-replicated commits don't queue through lockmanager.go's wait
+// queues the same way.
+func (kv *KVService) WaitingOn(request *kvs.WaitingOnRequest, response *kvs.WaitingOnResponse) error {
+	response.WaitingOn = kv.waitingOn(request.Txid)
+	return nil
+}
+
+// KillAsDeadlockVictim force-aborts request.Txid: kvs/client's deadlock
+// detector calls this on every shard the victim's Txn knows about (see
+// killTxn), once it finds a waits-for cycle involving it - see
+// killAsDeadlockVictim in lockmanager.go.
+func (kv *KVService) KillAsDeadlockVictim(request *kvs.KillDeadlockVictimRequest, response *kvs.KillDeadlockVictimResponse) error {
+	kv.killAsDeadlockVictim(request.Txid)
+	return nil
+}
+
+// recoverFromWAL replays this shard's log on startup. Committed
+// transactions are reapplied to mp (the in-memory store doesn't survive a
+// crash, the log does); still-prepared transactions reacquire their locks
+// and are handed to a background goroutine that asks the primary shard
+// named in their PREPARE record how they were supposed to end.
+func (kv *KVService) recoverFromWAL() {
+	records, err := kv.wal.ReadAll()
+	if err != nil {
+		log.Printf("wal recovery: failed to read log: %v", err)
+		return
+	}
+
+	type pending struct {
+		ops         []Operation
+		primaryAddr string
+		decision    string
+	}
+	byTxid := make(map[uint64]*pending)
+	for _, record := range records {
+		p, ok := byTxid[record.Txid]
+		if !ok {
+			p = &pending{}
+			byTxid[record.Txid] = p
+		}
+		switch record.Decision {
+		case "PREPARE":
+			p.ops = record.Ops
+			p.primaryAddr = record.PrimaryAddr
+		case "COMMIT", "ABORT":
+			p.decision = record.Decision
+		}
+	}
+
+	for txid, p := range byTxid {
+		switch p.decision {
+		case "COMMIT":
+			kv.decisions.Store(txid, "COMMIT")
+			for _, op := range p.ops {
+				if op.OpType == "PUT" {
+					kv.storeValue(op.Key, op.Value)
+				}
+			}
+		case "ABORT":
+			kv.decisions.Store(txid, "ABORT")
+		default:
+			// Still prepared when we crashed: reacquire locks so no one else
+			// can touch these keys until we learn the real outcome, and
+			// queue it for the background termination protocol below.
+			for _, op := range p.ops {
+				if op.OpType == "PUT" {
+					kv.acquireWriteLock(op.Key, txid)
+				} else {
+					kv.acquireReadLock(op.Key, txid)
+				}
+			}
+			kv.transactions.Store(txid, p.ops)
+			go kv.resolvePreparedTxn(txid, p.primaryAddr)
+		}
+	}
+}
+
+// resolvePreparedTxn implements the termination protocol for a transaction
+// that survived a crash in the PREPARE state: ask the elected primary what
+// happened and finish accordingly. If this shard *is* the primary and still
+// has no decision, the coordinator must have died before telling anyone to
+// commit - since the primary is always updated first (see Txn.Commit),
+// no other participant can have committed either, so it's safe to abort.
+// PrimaryAddr is never actually empty (client/main.go's Txn.Commit tells
+// every participant, including the primary itself, the primary's own
+// address), so "am I the primary" has to be primaryAddr == kv.selfAddr, not
+// primaryAddr == "" - dialing yourself and asking your own Status would
+// otherwise just poll forever with the keys locked permanently.
+func (kv *KVService) resolvePreparedTxn(txid uint64, primaryAddr string) {
+	const pollInterval = 100 * time.Millisecond
+
+	for {
+		if primaryAddr == "" || primaryAddr == kv.selfAddr {
+			kv.finishRecoveredTxn(txid, "ABORT")
+			return
+		}
+
+		rpcClient, err := rpc.DialHTTP("tcp", primaryAddr)
+		if err != nil {
+			time.Sleep(pollInterval)
+			continue
+		}
+
+		request := kvs.StatusRequest{Txid: txid}
+		response := kvs.StatusResponse{}
+		err = rpcClient.Call("KVService.Status", &request, &response)
+		rpcClient.Close()
+		if err != nil {
+			time.Sleep(pollInterval)
+			continue
+		}
+
+		if response.Decision == "" {
+			time.Sleep(pollInterval)
+			continue
+		}
+
+		kv.finishRecoveredTxn(txid, response.Decision)
+		return
+	}
+}
+
+func (kv *KVService) finishRecoveredTxn(txid uint64, decision string) {
+	kv.Lock()
+	defer kv.Unlock()
+
+	if decision == "COMMIT" {
+		if ops, found := kv.transactions.Load(txid); found {
+			for _, op := range ops.([]Operation) {
+				if op.OpType == "PUT" {
+					kv.storeValue(op.Key, op.Value)
+				}
+			}
+		}
+	}
+
+	if kv.wal != nil {
+		kv.wal.Append(WALRecord{Decision: decision, Txid: txid})
+	}
+	kv.decisions.Store(txid, decision)
+	kv.releaseLocks(txid)
+}
+
+// evalCompare checks a single Compare predicate against the key's current
+// value/version/existence.
+func evalCompare(cmp kvs.Compare, current VersionedValue, exists bool) bool {
+	switch cmp.Target {
+	case kvs.CompareExists:
+		return exists == cmp.Exists
+	case kvs.CompareVersion:
+		return compareOrdered(current.Version, cmp.Version, cmp.Op)
+	default: // kvs.CompareValue
+		return compareStrings(current.Value, cmp.Value, cmp.Op)
+	}
+}
+
+func compareOrdered(have, want uint64, op kvs.CompareOp) bool {
+	switch op {
+	case kvs.CmpEqual:
+		return have == want
+	case kvs.CmpNotEqual:
+		return have != want
+	case kvs.CmpGreater:
+		return have > want
+	case kvs.CmpLess:
+		return have < want
+	default:
+		return false
+	}
+}
+
+func compareStrings(have, want string, op kvs.CompareOp) bool {
+	switch op {
+	case kvs.CmpEqual:
+		return have == want
+	case kvs.CmpNotEqual:
+		return have != want
+	case kvs.CmpGreater:
+		return have > want
+	case kvs.CmpLess:
+		return have < want
+	default:
+		return false
+	}
+}
+
+// ConditionalCommit evaluates request.Cmps against the current store state
+// and atomically applies Then (all predicates held) or Else (otherwise),
+// all under locks acquired and released within this single RPC - an
+// etcd-style compare-and-swap that needs no prior Get/Put round trip.
+func (kv *KVService) ConditionalCommit(request *kvs.ConditionalCommitRequest, response *kvs.ConditionalCommitResponse) error {
+	// No kv.Lock() here: acquireReadLock/acquireWriteLock below can block in
+	// a wait-queue (see lockmanager.go), and holding the service-wide lock
+	// across that wait would stop any other holder's Commit/Abort - which
+	// needs kv.Lock() too - from ever running to release it.
+	response.Values = make(map[string]string, len(request.Cmps))
+
+	// Track exactly which keys we lock during this call so we can unlock
+	// them again before returning - ConditionalCommit is self-contained and
+	// never registers ops in kv.transactions the way Get/Put/Commit do.
+	var lockedKeys []string
+	releaseAll := func() {
+		for _, key := range lockedKeys {
+			kv.releaseKeyLock(key, request.Txid)
+		}
+	}
+
+	// Acquire a read lock on every key a predicate inspects so a concurrent
+	// writer can't change the answer between evaluation and apply.
+	for _, cmp := range request.Cmps {
+		if err := kv.acquireReadLock(cmp.Key, request.Txid); err != nil {
+			releaseAll()
+			atomic.AddUint64(&kv.stats.aborts, 1)
+			return err
+		}
+		lockedKeys = append(lockedKeys, cmp.Key)
+	}
+
+	succeeded := true
+	for _, cmp := range request.Cmps {
+		current := kv.loadValue(cmp.Key)
+		exists := current.Version > 0 // a never-written key decodes to the zero VersionedValue
+		response.Values[cmp.Key] = current.Value
+		if !evalCompare(cmp, current, exists) {
+			succeeded = false
+		}
+	}
+
+	branch := request.Else
+	if succeeded {
+		branch = request.Then
+	}
+
+	for _, op := range branch {
+		if err := kv.acquireWriteLock(op.Key, request.Txid); err != nil {
+			releaseAll()
+			atomic.AddUint64(&kv.stats.aborts, 1)
+			return err
+		}
+		lockedKeys = append(lockedKeys, op.Key)
+	}
+	for _, op := range branch {
+		if op.OpType == "PUT" {
+			kv.storeValue(op.Key, op.Value)
+		}
+	}
+
+	releaseAll()
+	response.Succeeded = succeeded
+	atomic.AddUint64(&kv.stats.commits, 1)
+	return nil
+}
+
 func (kv *KVService) printStats() {
 	kv.RLock() // Read lock
 	stats := kv.stats
@@ -275,10 +975,66 @@ func (kv *KVService) printStats() {
 
 func main() {
 	port := flag.String("port", "8080", "Port to run the server on") // return *string(pointer)
+	cc := flag.String("cc", "2pl", "Concurrency control mode: 2pl or mvcc")
+	gcInterval := flag.Duration("gc-interval", 10*time.Second, "How often to run MVCC GC (only used with -cc=mvcc)")
+	gcLag := flag.Uint64("gc-lag-ts", 10000, "MVCC GC safe point = latest timestamp minus this many ticks (only used with -cc=mvcc)")
+	walPath := flag.String("wal", "", "Path to this shard's 2PC write-ahead log (default: wal-<port>.log, only used with -cc=2pl)")
+	lockTimeout := flag.Duration("lock-timeout", defaultLockTimeout, "How long a 2PL Get/Put waits in a lock's queue before giving up (only used with -cc=2pl)")
+	replicas := flag.String("replicas", "", "Comma-separated host:port list of this shard's Raft replica group (enables replicated mode; only used with -cc=2pl)")
+	me := flag.Int("me", 0, "This process's index within -replicas")
+	selfAddr := flag.String("self-addr", "", "This shard's own host:port, as clients dial it (default: localhost:<port>; only used with -cc=2pl, to recognize its own address in a 2PC PrimaryAddr)")
 	flag.Parse()
 
-	kvs := NewKVService()
+	var kvs *KVService
+	switch *cc {
+	case "2pl":
+		kvs = NewKVService()
+		kvs.lockTimeout = *lockTimeout
+		kvs.selfAddr = *selfAddr
+		if kvs.selfAddr == "" {
+			kvs.selfAddr = fmt.Sprintf("localhost:%s", *port)
+		}
+
+		if *replicas == "" {
+			path := *walPath
+			if path == "" {
+				path = fmt.Sprintf("wal-%s.log", *port)
+			}
+			wal, err := NewWAL(path)
+			if err != nil {
+				log.Fatalf("failed to open WAL %s: %v", path, err)
+			}
+			kvs.wal = wal
+			kvs.recoverFromWAL()
+		} else {
+			// -replicas mode replicates Put/Get/Commit/Abort through Raft (see
+			// the kv.raft != nil branches above), so this replica's local 2PC
+			// WAL never gets written to by normal operation and has nothing of
+			// its own to recover - replaying it here would resolve a prepared
+			// txn unilaterally from this one replica's local state, outside
+			// Raft consensus, and could diverge from whatever the rest of the
+			// group's Raft log actually decided. kv.wal stays nil; this replica
+			// instead catches up the ordinary Raft way - startRaft joins the
+			// group as a fresh, empty-log follower, and AppendEntries from
+			// whichever peer is leader replicates the group's existing log (and
+			// so the shard's state) onto it, same as recovering any lagging
+			// follower. raftkv.Raft keeps no persisted or snapshotted state of
+			// its own to replay (see its doc comment) - this only works because
+			// a majority of the group is still alive to replicate from.
+			kvs.startRaft(strings.Split(*replicas, ","), *me)
+		}
+	case "mvcc":
+		if *replicas != "" {
+			log.Fatalf("-replicas is not supported with -cc=mvcc")
+		}
+		kvs = NewMVCCKVService()
+	default:
+		log.Fatalf("unknown -cc mode %q, expected 2pl or mvcc", *cc)
+	}
 	rpc.Register(kvs)
+	if kvs.raft != nil {
+		rpc.Register(kvs.raft)
+	}
 	/* Regester functions with signature: func (t *T) MethodName(args *ArgsType, reply *ReplyType) error
 
 	   So, in this project, this will "register" these methods:
@@ -304,7 +1060,7 @@ func main() {
 		log.Fatal("listen error:", e)
 	}
 
-	fmt.Printf("Starting KVS server on :%s\n", *port)
+	fmt.Printf("Starting KVS server on :%s (cc=%s)\n", *port, *cc)
 
 	go func() {
 		for {
@@ -313,5 +1069,22 @@ func main() {
 		}
 	}()
 
+	if kvs.mvcc != nil {
+		go func() {
+			for {
+				time.Sleep(*gcInterval)
+				latest := kvs.mvcc.BeginStartTs()
+				safepoint := uint64(0)
+				if latest > *gcLag {
+					safepoint = latest - *gcLag
+				}
+				removed := kvs.mvcc.GC(safepoint)
+				if removed > 0 {
+					log.Printf("mvcc gc: compacted %d versions older than ts %d", removed, safepoint)
+				}
+			}
+		}()
+	}
+
 	http.Serve(l, nil)
 }
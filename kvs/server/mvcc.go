@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TimestampOracle hands out monotonically increasing timestamps, standing in
+// for the centralized TSO that a real distributed deployment (e.g. TiKV)
+// would run as its own service. A plain atomic counter is enough here since
+// every shard in this lab runs the oracle in-process.
+type TimestampOracle struct {
+	counter uint64
+}
+
+func (o *TimestampOracle) Next() uint64 {
+	return atomic.AddUint64(&o.counter, 1)
+}
+
+// mvccLock is the prewrite record left behind for a key until the owning
+// transaction commits or rolls back. primary identifies the key used to
+// decide the fate of the whole transaction (see resolveLock).
+type mvccLock struct {
+	startTs uint64
+	primary string
+}
+
+// MVCCStore implements TiKV/unistore-style multi-version concurrency control
+// on top of a single ordered keyspace: committed versions are stored as
+// "key||^commit_ts" entries in a SkipList so that, for a fixed key, newer
+// versions sort before older ones (see encodeVersionKey), and reads simply
+// seek to the newest version at or before their start_ts.
+type MVCCStore struct {
+	oracle        TimestampOracle
+	versions      *SkipList // "key||^commit_ts" -> value
+	locks         sync.Map  // key -> *mvccLock
+	pendingValues sync.Map  // lockKey -> string, values staged by Prewrite until Commit
+	gcSafe        atomic.Uint64
+
+	// primaryDecisions records how a transaction's primary key was resolved,
+	// keyed by startTs: a committed commit_ts (always > 0, since the oracle's
+	// first tick is 1), or 0 for rolled back. Populated by Commit/Rollback
+	// whenever they're applied to a key that is its own transaction's
+	// primary (see mvccLock.primary) - checkLock consults this to decide
+	// whether a stale secondary lock should be rolled forward or back.
+	primaryDecisions sync.Map
+}
+
+func NewMVCCStore() *MVCCStore {
+	return &MVCCStore{versions: NewSkipList()}
+}
+
+// encodeVersionKey builds the composite skiplist key for a (key, commit_ts)
+// pair. Encoding the timestamp as its bitwise complement means that, for a
+// fixed key prefix, larger commit_ts values sort first - so Seek lands on
+// the newest visible version with a single lower-bound lookup.
+func encodeVersionKey(key string, commitTs uint64) []byte {
+	buf := make([]byte, len(key)+1+8)
+	copy(buf, key)
+	buf[len(key)] = 0xff // separator; keys never naturally contain 0xff from our callers
+	binary.BigEndian.PutUint64(buf[len(key)+1:], ^commitTs)
+	return buf
+}
+
+func decodeVersionKeyPrefix(composite []byte, keyLen int) bool {
+	return len(composite) == keyLen+1+8 && composite[keyLen] == 0xff
+}
+
+// BeginStartTs hands the client a snapshot timestamp for a new transaction.
+func (m *MVCCStore) BeginStartTs() uint64 {
+	return m.oracle.Next()
+}
+
+// Get returns the newest committed version of key visible at startTs,
+// resolving any lock left behind by a conflicting in-flight transaction.
+func (m *MVCCStore) Get(key string, startTs uint64) (string, error) {
+	if err := m.checkLock(key, startTs); err != nil {
+		return "", err
+	}
+
+	target := encodeVersionKey(key, startTs)
+	composite, value, ok := m.versions.Seek(target)
+	if !ok || !decodeVersionKeyPrefix(composite, len(key)) || string(composite[:len(key)]) != key {
+		return "", nil
+	}
+	return value, nil
+}
+
+// checkLock implements the "read through lock" path: if a lock exists and
+// belongs to a transaction older than the reader, that transaction's fate
+// is decided by its primary key alone (see mvccLock.primary), so we check
+// primaryDecisions for it - rolling this lock forward if the primary
+// committed, or back if the primary rolled back - instead of just waiting
+// on it to go away on its own. We still poll briefly for the case where the
+// primary itself hasn't been resolved yet (the owning transaction is still
+// mid-commit), so a reader never blocks past maxWait behind a dead one.
+func (m *MVCCStore) checkLock(key string, startTs uint64) error {
+	const pollInterval = 5 * time.Millisecond
+	const maxWait = 200 * time.Millisecond
+
+	deadline := time.Now().Add(maxWait)
+	for {
+		lockVal, found := m.locks.Load(key)
+		if !found {
+			return nil
+		}
+		lock := lockVal.(*mvccLock)
+		if lock.startTs >= startTs {
+			// Lock belongs to a transaction that started after us; it cannot
+			// be in our snapshot, so it's invisible to this read.
+			return nil
+		}
+
+		if decisionVal, resolved := m.primaryDecisions.Load(lock.startTs); resolved {
+			if commitTs := decisionVal.(uint64); commitTs > 0 {
+				_ = m.Commit(key, lock.startTs, commitTs) // roll forward
+			} else {
+				m.Rollback(key, lock.startTs) // roll back: primary never committed
+			}
+			continue // lock is now resolved locally; loop re-checks and returns nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("lock conflict: key %q locked by older txn %d", key, lock.startTs)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// newestCommit returns the commit_ts of the newest committed version of key,
+// if any. Backs Prewrite's write-write conflict check: seeking with the
+// maximum possible commit_ts lands on the newest version with a single
+// lower-bound lookup, the same trick Get uses to find the newest version
+// visible at a given startTs (see encodeVersionKey).
+func (m *MVCCStore) newestCommit(key string) (uint64, bool) {
+	target := encodeVersionKey(key, ^uint64(0))
+	composite, _, ok := m.versions.Seek(target)
+	if !ok || !decodeVersionKeyPrefix(composite, len(key)) || string(composite[:len(key)]) != key {
+		return 0, false
+	}
+	return ^binary.BigEndian.Uint64(composite[len(key)+1:]), true
+}
+
+// Prewrite stages a write under a lock record. commit only succeeds once
+// every key in the transaction has been prewritten successfully.
+func (m *MVCCStore) Prewrite(key string, value string, startTs uint64, primary string) error {
+	if commitTs, found := m.newestCommit(key); found && commitTs > startTs {
+		return fmt.Errorf("write conflict: key %q was committed at %d, after our snapshot %d (first committer wins)", key, commitTs, startTs)
+	}
+	if lockVal, found := m.locks.Load(key); found {
+		lock := lockVal.(*mvccLock)
+		if lock.startTs != startTs {
+			return fmt.Errorf("lock conflict: key %q already locked by txn %d", key, lock.startTs)
+		}
+		return nil // re-prewrite of our own lock, idempotent
+	}
+	m.locks.Store(key, &mvccLock{startTs: startTs, primary: primary})
+	m.pendingValues.Store(lockKey{key, startTs}, value)
+	return nil
+}
+
+// Commit converts a prewritten lock into a durable version at commitTs and
+// releases the lock. Calling Commit without a matching Prewrite is an error,
+// mirroring how a real 2PC participant would refuse an out-of-order commit.
+func (m *MVCCStore) Commit(key string, startTs uint64, commitTs uint64) error {
+	lockVal, found := m.locks.Load(key)
+	if !found {
+		return errors.New("commit: no lock held for key (already committed or rolled back?)")
+	}
+	lock := lockVal.(*mvccLock)
+	if lock.startTs != startTs {
+		return fmt.Errorf("commit: lock for key belongs to txn %d, not %d", lock.startTs, startTs)
+	}
+	if lock.primary == key {
+		m.primaryDecisions.Store(startTs, commitTs)
+	}
+
+	value, _ := m.pendingValues.Load(lockKey{key, startTs})
+	m.versions.Insert(encodeVersionKey(key, commitTs), value.(string))
+	m.pendingValues.Delete(lockKey{key, startTs})
+	m.locks.Delete(key)
+	return nil
+}
+
+// Rollback discards a prewritten lock without materializing a version,
+// used both for explicit aborts and for cleaning up stale locks found by a
+// reader during checkLock's resolution path.
+func (m *MVCCStore) Rollback(key string, startTs uint64) {
+	if lockVal, found := m.locks.Load(key); found {
+		lock := lockVal.(*mvccLock)
+		if lock.startTs == startTs {
+			if lock.primary == key {
+				m.primaryDecisions.Store(startTs, uint64(0))
+			}
+			m.locks.Delete(key)
+			m.pendingValues.Delete(lockKey{key, startTs})
+		}
+	}
+}
+
+// lockKey scopes a pending prewritten value to its transaction so a retried
+// or concurrent prewrite on the same key from a different txn can't clobber
+// the value until one of them actually commits.
+type lockKey struct {
+	key     string
+	startTs uint64
+}
+
+// GC drops committed versions older than safepoint, keeping at least the
+// newest version at or below it so reads with start_ts == safepoint still
+// see a value. main() runs this periodically from a background goroutine
+// with a safepoint of the latest timestamp minus -gc-lag-ts; that choice
+// does not look at in-flight start_ts values, so a reader whose start_ts
+// is below the safepoint can find the versions it would have read gone.
+func (m *MVCCStore) GC(safepoint uint64) int {
+	type seen struct {
+		keptOne bool
+	}
+	newestKept := make(map[string]uint64)
+	var toDelete [][]byte
+
+	m.versions.ForEach(func(composite []byte, value string) {
+		keyLen := len(composite) - 1 - 8
+		if keyLen < 0 || composite[keyLen] != 0xff {
+			return
+		}
+		userKey := string(composite[:keyLen])
+		commitTs := ^binary.BigEndian.Uint64(composite[keyLen+1:])
+		if commitTs > safepoint {
+			return // still visible to future readers at/above this ts
+		}
+		if _, already := newestKept[userKey]; already {
+			// We already kept the newest <= safepoint version for this key;
+			// everything older is dead weight.
+			cp := make([]byte, len(composite))
+			copy(cp, composite)
+			toDelete = append(toDelete, cp)
+			return
+		}
+		newestKept[userKey] = commitTs
+	})
+
+	for _, composite := range toDelete {
+		m.versions.Delete(composite)
+	}
+	m.gcSafe.Store(safepoint)
+	return len(toDelete)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+)
+
+// skipListMaxLevel bounds how tall the list can grow; 32 levels comfortably
+// supports the millions of versioned entries a long-running MVCC store
+// accumulates before GC catches up.
+const skipListMaxLevel = 32
+
+type skipListNode struct {
+	key   []byte
+	value string
+	next  []*skipListNode
+}
+
+// SkipList is a minimal ordered map keyed by raw bytes, used by the MVCC
+// engine to store "key||^commit_ts" entries so that versions of the same
+// user key sort newest-first (see mvcc.go). It's intentionally small: just
+// enough Insert/Seek to support point lookups and floor-style scans without
+// pulling in an external dependency.
+type SkipList struct {
+	mu     sync.RWMutex
+	head   *skipListNode
+	level  int
+	rand   *rand.Rand
+	length int
+}
+
+func NewSkipList() *SkipList {
+	return &SkipList{
+		head:  &skipListNode{next: make([]*skipListNode, skipListMaxLevel)},
+		level: 1,
+		rand:  rand.New(rand.NewSource(0xC0FFEE)),
+	}
+}
+
+func (s *SkipList) randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && s.rand.Int31()&1 == 1 {
+		level++
+	}
+	return level
+}
+
+// Insert adds or overwrites the entry at key. MVCC composite keys are unique
+// per (key, commit_ts) pair so in practice this always inserts; overwrite is
+// supported for symmetry with a plain map.
+func (s *SkipList) Insert(key []byte, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update := make([]*skipListNode, skipListMaxLevel)
+	cur := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for cur.next[i] != nil && bytes.Compare(cur.next[i].key, key) < 0 {
+			cur = cur.next[i]
+		}
+		update[i] = cur
+	}
+
+	if next := cur.next[0]; next != nil && bytes.Equal(next.key, key) {
+		next.value = value
+		return
+	}
+
+	newLevel := s.randomLevel()
+	if newLevel > s.level {
+		for i := s.level; i < newLevel; i++ {
+			update[i] = s.head
+		}
+		s.level = newLevel
+	}
+
+	node := &skipListNode{key: key, value: value, next: make([]*skipListNode, newLevel)}
+	for i := 0; i < newLevel; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	s.length++
+}
+
+// Seek returns the first entry with key >= target (a lower bound), or
+// ok == false if none exists. MVCC reads use this to land on the newest
+// version whose commit_ts is <= the reader's start_ts.
+func (s *SkipList) Seek(target []byte) (key []byte, value string, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cur := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for cur.next[i] != nil && bytes.Compare(cur.next[i].key, target) < 0 {
+			cur = cur.next[i]
+		}
+	}
+	next := cur.next[0]
+	if next == nil {
+		return nil, "", false
+	}
+	return next.key, next.value, true
+}
+
+// Delete removes the exact entry at key, if present. The MVCC GC pass uses
+// this to compact individual obsolete versions found while walking the list.
+func (s *SkipList) Delete(key []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update := make([]*skipListNode, s.level)
+	cur := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for cur.next[i] != nil && bytes.Compare(cur.next[i].key, key) < 0 {
+			cur = cur.next[i]
+		}
+		update[i] = cur
+	}
+
+	victim := cur.next[0]
+	if victim == nil || !bytes.Equal(victim.key, key) {
+		return false
+	}
+	for i := 0; i < len(victim.next); i++ {
+		if update[i].next[i] == victim {
+			update[i].next[i] = victim.next[i]
+		}
+	}
+	s.length--
+	return true
+}
+
+// ForEach walks every entry in ascending key order. The GC pass uses this to
+// find, per user key, which versions fall below the safe point.
+func (s *SkipList) ForEach(fn func(key []byte, value string)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for cur := s.head.next[0]; cur != nil; cur = cur.next[0] {
+		fn(cur.key, cur.value)
+	}
+}
+
+// Range walks entries in ascending key order starting from the first key
+// >= start (or from the very first entry if start is nil), calling fn for
+// each one. It stops when end is non-nil and a key reaches end (end is
+// exclusive, so the key equal to end is not visited), or as soon as fn
+// returns false - the latter is how a caller enforces a result limit
+// without walking the rest of the list. end == nil means unbounded; an
+// empty-but-non-nil end would instead match immediately, since an empty
+// slice compares less than every real key.
+func (s *SkipList) Range(start, end []byte, fn func(key []byte, value string) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cur := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for cur.next[i] != nil && bytes.Compare(cur.next[i].key, start) < 0 {
+			cur = cur.next[i]
+		}
+	}
+
+	for n := cur.next[0]; n != nil; n = n.next[0] {
+		if end != nil && bytes.Compare(n.key, end) >= 0 {
+			return
+		}
+		if !fn(n.key, n.value) {
+			return
+		}
+	}
+}
+
+func (s *SkipList) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.length
+}
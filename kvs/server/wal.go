@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// WALRecord is one line of a shard's write-ahead log. A transaction's life
+// in the log is: one "PREPARE" record capturing its buffered ops, followed
+// eventually by exactly one "COMMIT" or "ABORT" record. A crash between the
+// two leaves the transaction PREPARE-only, which is exactly the state
+// startup recovery (see recoverFromWAL) looks for.
+type WALRecord struct {
+	Decision    string // "PREPARE", "COMMIT", or "ABORT"
+	Txid        uint64
+	Ops         []Operation // only populated on PREPARE; replayed on recovery
+	PrimaryAddr string      // only populated on PREPARE; who to ask if we crash before deciding
+}
+
+// WAL is a minimal append-only, fsync'd log: one JSON object per line. It's
+// intentionally simple (no compaction, no checksums) since its only job
+// here is to survive the process crashing between Prepare and the final
+// Commit/Abort, per the 2PC recovery protocol in main.go.
+type WAL struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewWAL(path string) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WAL{file: f}, nil
+}
+
+// Append durably writes record to the log before returning.
+func (w *WAL) Append(record WALRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := w.file.Write(line); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// ReadAll returns every record in the log in the order they were written,
+// for use by startup recovery.
+func (w *WAL) ReadAll() ([]WALRecord, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	defer w.file.Seek(0, 2) // back to the end so subsequent Appends keep appending
+
+	var records []WALRecord
+	scanner := bufio.NewScanner(w.file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record WALRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue // tolerate a torn trailing write from a crash mid-Append
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}